@@ -0,0 +1,341 @@
+// Command mockidp is a tiny OpenID Connect provider for trying out and
+// testing SSO login locally. Its login page asks for any email address and
+// name, and signs you in as that person - never expose it to the internet.
+//
+//	go run ./cmd/mockidp
+//	go run ./cmd/web -oidc-issuer=http://localhost:4001 -oidc-client-id=habit-tracker -oidc-client-secret=secret
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const keyID = "mockidp-1"
+
+// codeTTL is how long an authorization code can be exchanged for
+const codeTTL = time.Minute
+
+type provider struct {
+	logger       *slog.Logger
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// authorization is what the user agreed to on the login page, waiting for
+// the client to exchange its code.
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	challenge     string
+	nonce         string
+	email         string
+	name          string
+	emailVerified bool
+	expires       time.Time
+}
+
+func main() {
+	addr := flag.String("addr", ":4001", "HTTP network address")
+	issuer := flag.String("issuer", "http://localhost:4001", "Issuer URL, as clients reach it")
+	clientID := flag.String("client-id", "habit-tracker", "The only client ID accepted")
+	clientSecret := flag.String("client-secret", "secret", "Client secret (empty for a public client)")
+	flag.Parse()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		logger.Error("Generating signing key failed", "error", err)
+		os.Exit(1)
+	}
+
+	p := &provider{
+		logger:       logger,
+		issuer:       strings.TrimSuffix(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		key:          key,
+		codes:        make(map[string]authorization),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("GET /authorize", p.authorizeForm)
+	mux.HandleFunc("POST /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+
+	logger.Info("Mock identity provider listening", "addr", *addr, "issuer", p.issuer, "client_id", p.clientID)
+	err = http.ListenAndServe(*addr, mux)
+	logger.Error("Server error", "error", err)
+	os.Exit(1)
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="UTF-8"><title>Mock Identity Provider</title></head>
+<body style="font-family: sans-serif; max-width: 28rem; margin: 3rem auto;">
+    <h1>Mock Identity Provider</h1>
+    <p>Sign in to <strong>{{.ClientID}}</strong> as anyone. For local testing only.</p>
+    <form method="POST" action="/authorize">
+        {{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">{{end}}
+        <p><label>Email<br><input type="email" name="email" value="user@example.com" required></label></p>
+        <p><label>Name<br><input type="text" name="name" value="Test User"></label></p>
+        <p><label><input type="checkbox" name="email_verified" value="true" checked> Email verified</label></p>
+        <p><button type="submit">Sign In</button></p>
+    </form>
+</body>
+</html>`))
+
+// authorizeForm shows the login page after checking the client's request.
+// Problems with the client or redirect URI are shown here rather than
+// redirected, as the redirect URI can't be trusted.
+func (p *provider) authorizeForm(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if !p.checkAuthorizeRequest(w, query) {
+		return
+	}
+
+	params := make(map[string]string)
+	for _, name := range []string{"client_id", "redirect_uri", "state", "nonce", "code_challenge"} {
+		params[name] = query.Get(name)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := loginPage.Execute(w, map[string]any{"ClientID": p.clientID, "Params": params})
+	if err != nil {
+		p.logger.Error("Rendering login page failed", "error", err)
+	}
+}
+
+func (p *provider) checkAuthorizeRequest(w http.ResponseWriter, query url.Values) bool {
+	switch {
+	case query.Get("client_id") != p.clientID:
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+	case query.Get("redirect_uri") == "":
+		http.Error(w, "redirect_uri is required", http.StatusBadRequest)
+	case query.Get("response_type") != "" && query.Get("response_type") != "code":
+		http.Error(w, "only response_type=code is supported", http.StatusBadRequest)
+	case query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256":
+		http.Error(w, "PKCE with code_challenge_method=S256 is required", http.StatusBadRequest)
+	case query.Get("scope") != "" && !strings.Contains(" "+query.Get("scope")+" ", " openid "):
+		http.Error(w, "the openid scope is required", http.StatusBadRequest)
+	default:
+		return true
+	}
+	return false
+}
+
+// authorize signs the user in and redirects back to the client with a code.
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	form := r.PostForm
+	form.Set("response_type", "code")
+	form.Set("code_challenge_method", "S256")
+	if !p.checkAuthorizeRequest(w, form) {
+		return
+	}
+
+	redirectURI, err := url.Parse(form.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	for c, auth := range p.codes {
+		if time.Now().After(auth.expires) {
+			delete(p.codes, c)
+		}
+	}
+	p.codes[code] = authorization{
+		clientID:      form.Get("client_id"),
+		redirectURI:   form.Get("redirect_uri"),
+		challenge:     form.Get("code_challenge"),
+		nonce:         form.Get("nonce"),
+		email:         strings.TrimSpace(form.Get("email")),
+		name:          strings.TrimSpace(form.Get("name")),
+		emailVerified: form.Get("email_verified") == "true",
+		expires:       time.Now().Add(codeTTL),
+	}
+	p.mu.Unlock()
+
+	query := redirectURI.Query()
+	query.Set("code", code)
+	if state := form.Get("state"); state != "" {
+		query.Set("state", state)
+	}
+	redirectURI.RawQuery = query.Encode()
+
+	p.logger.Info("Signed in", "email", form.Get("email"), "redirect_uri", form.Get("redirect_uri"))
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// token exchanges a code for an ID token, checking the client and the PKCE
+// code verifier.
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.clientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.clientSecret)) != 1 {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	// Codes work once, even if the exchange fails
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	auth, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok || time.Now().After(auth.expires) || auth.clientID != clientID:
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired code")
+		return
+	case auth.redirectURI != r.PostForm.Get("redirect_uri"):
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri doesn't match")
+		return
+	case base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.challenge:
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "code_verifier doesn't match code_challenge")
+		return
+	}
+
+	// The same email always gets the same subject
+	subject := sha256.Sum256([]byte(strings.ToLower(auth.email)))
+	now := time.Now()
+	claims := map[string]any{
+		"iss":            p.issuer,
+		"sub":            hex.EncodeToString(subject[:8]),
+		"aud":            clientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"email":          auth.email,
+		"email_verified": auth.emailVerified,
+		"name":           auth.name,
+	}
+	if auth.nonce != "" {
+		claims["nonce"] = auth.nonce
+	}
+
+	idToken, err := p.sign(claims)
+	if err != nil {
+		p.logger.Error("Signing ID token failed", "error", err)
+		tokenError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// sign returns claims as an RS256 signed JWT.
+func (p *provider) sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
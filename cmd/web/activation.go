@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+	"github.com/amari03/habit-tracker/internal/validator"
+)
+
+// activationTokenTTL is how long an activation link stays valid
+const activationTokenTTL = 3 * 24 * time.Hour
+
+// background runs fn in a goroutine, logging rather than crashing on a panic,
+// so slow work like sending email doesn't hold up the response. Shutdown waits
+// for it to finish.
+func (app *application) background(fn func()) {
+	app.workers.Go("background task", func(context.Context) error {
+		fn()
+		return nil
+	})
+}
+
+// sendActivationEmail creates a fresh activation token for the user and
+// emails them the link in the background.
+func (app *application) sendActivationEmail(user *data.User) error {
+	token, err := app.userTokens.New(user.ID, activationTokenTTL, data.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	emailData := map[string]any{
+		"Name":          user.Name,
+		"ActivationURL": app.baseURL + "/user/activate?token=" + url.QueryEscape(token.Plaintext),
+		"ExpiresIn":     "3 days",
+	}
+
+	app.background(func() {
+		err := app.mailer.Send(user.Email, "user_activation.tmpl", emailData)
+		if err != nil {
+			app.logger.Error("sending activation email", "user_id", user.ID, "error", err)
+		}
+	})
+	return nil
+}
+
+// activateUserForm shows the button that activates the account. The link in
+// the email only opens this page, so mail scanners that follow links don't
+// use up the token.
+func (app *application) activateUserForm(w http.ResponseWriter, r *http.Request) {
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Activate Account"
+	templatePageData.Flash = app.session.PopString(r, "flash")
+	templatePageData.FormData = map[string]string{"token": r.URL.Query().Get("token")}
+
+	err := app.render(w, r, http.StatusOK, "activate.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// activateUser activates the account the token was sent for
+func (app *application) activateUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	tokenPlaintext := r.PostForm.Get("token")
+
+	v := validator.NewValidator()
+	data.ValidateTokenPlaintext(v, tokenPlaintext)
+	if !v.ValidData() {
+		app.renderActivationError(w, r)
+		return
+	}
+
+	user, err := app.userTokens.GetUserForToken(data.ScopeActivation, tokenPlaintext)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.renderActivationError(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	user.Active = true
+	err = app.users.Update(user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.userTokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your account has been activated! Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// renderActivationError explains that the link didn't work and offers a new one
+func (app *application) renderActivationError(w http.ResponseWriter, r *http.Request) {
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Activate Account - Error"
+	templatePageData.FormErrors = map[string]string{
+		"generic": "This activation link is invalid, has expired or has already been used.",
+	}
+
+	err := app.render(w, r, http.StatusUnprocessableEntity, "activate.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// resendActivation emails a new activation link. The response is the same
+// whether or not the address belongs to an inactive account, so the form
+// can't be used to find out who has signed up.
+func (app *application) resendActivation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	email := r.PostForm.Get("email")
+
+	v := validator.NewValidator()
+	v.Check(validator.NotBlank(email), "email", "must be provided")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	if !v.ValidData() {
+		templatePageData := NewTemplateData()
+		templatePageData.Title = "Activate Account - Error"
+		templatePageData.FormErrors = v.Errors
+		templatePageData.FormData = map[string]string{"email": email}
+		err := app.render(w, r, http.StatusUnprocessableEntity, "activate.tmpl", templatePageData)
+		if err != nil {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.users.GetByEmail(email)
+	switch {
+	case err == nil && !user.Active:
+		err = app.sendActivationEmail(user)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "If "+email+" belongs to an account that still needs activating, a new link is on its way.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/amari03/habit-tracker/internal/data"
+	"github.com/amari03/habit-tracker/internal/validator"
+)
+
+// apiListHabitsHandler returns the user's habits with their status for the
+// current period and their streaks. ?frequency= limits the list to one frequency.
+func (app *application) apiListHabitsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	frequencies := data.PermittedFrequencies
+	if frequency := r.URL.Query().Get("frequency"); frequency != "" {
+		if !validator.PermittedValue(frequency, data.PermittedFrequencies...) {
+			app.failedValidationResponse(w, r, map[string]string{"frequency": "must be 'daily', 'weekly' or 'custom'"})
+			return
+		}
+		frequencies = []string{frequency}
+	}
+
+	today := app.today(r)
+	habits := []*data.Habit{}
+	for _, frequency := range frequencies {
+		statuses, _, err := app.loadHabitStatuses(userID, frequency, today)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		habits = append(habits, statuses...)
+	}
+
+	err := app.loadStreaks(habits, today)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"habits": habits}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// apiCreateHabitHandler creates a habit from a JSON body
+func (app *application) apiCreateHabitHandler(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	var input struct {
+		Title        string        `json:"title"`
+		Description  string        `json:"description"`
+		Frequency    string        `json:"frequency"`
+		Goal         string        `json:"goal"`
+		TimesPerWeek int           `json:"times_per_week"`
+		Weekdays     data.Weekdays `json:"weekdays"`
+		TargetValue  float64       `json:"target_value"`
+		Unit         string        `json:"unit"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	habit := &data.Habit{
+		UserID:       userID,
+		Title:        input.Title,
+		Description:  input.Description,
+		Frequency:    input.Frequency,
+		Goal:         input.Goal,
+		TimesPerWeek: input.TimesPerWeek,
+		Weekdays:     input.Weekdays,
+		TargetValue:  input.TargetValue,
+		Unit:         strings.TrimSpace(input.Unit),
+	}
+
+	v := validator.NewValidator()
+	data.ValidateHabit(v, habit)
+	if !v.ValidData() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.habits.Insert(habit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", "/api/v1/habits/"+strconv.FormatInt(habit.ID, 10))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"habit": habit}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// apiShowHabitHandler returns a single habit with its streak
+func (app *application) apiShowHabitHandler(w http.ResponseWriter, r *http.Request) {
+	habit := app.apiOwnedHabit(w, r, app.authenticatedUserID(r))
+	if habit == nil {
+		return
+	}
+
+	err := app.loadStreaks([]*data.Habit{habit}, app.today(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"habit": habit}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// apiUpdateHabitHandler applies a partial update: only the fields present in
+// the JSON body are changed.
+func (app *application) apiUpdateHabitHandler(w http.ResponseWriter, r *http.Request) {
+	habit := app.apiOwnedHabit(w, r, app.authenticatedUserID(r))
+	if habit == nil {
+		return
+	}
+
+	var input struct {
+		Title        *string        `json:"title"`
+		Description  *string        `json:"description"`
+		Frequency    *string        `json:"frequency"`
+		Goal         *string        `json:"goal"`
+		TimesPerWeek *int           `json:"times_per_week"`
+		Weekdays     *data.Weekdays `json:"weekdays"`
+		TargetValue  *float64       `json:"target_value"`
+		Unit         *string        `json:"unit"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Title != nil {
+		habit.Title = *input.Title
+	}
+	if input.Description != nil {
+		habit.Description = *input.Description
+	}
+	if input.Frequency != nil {
+		habit.Frequency = *input.Frequency
+	}
+	if input.Goal != nil {
+		habit.Goal = *input.Goal
+	}
+	if input.TimesPerWeek != nil {
+		habit.TimesPerWeek = *input.TimesPerWeek
+	}
+	if input.Weekdays != nil {
+		habit.Weekdays = *input.Weekdays
+	}
+	if input.TargetValue != nil {
+		habit.TargetValue = *input.TargetValue
+	}
+	if input.Unit != nil {
+		habit.Unit = strings.TrimSpace(*input.Unit)
+	}
+
+	// As with the edit form, switching away from custom drops the schedule
+	if habit.Frequency != "custom" {
+		habit.TimesPerWeek = 0
+		habit.Weekdays = 0
+	}
+
+	v := validator.NewValidator()
+	data.ValidateHabit(v, habit)
+	if !v.ValidData() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.habits.Update(habit)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"habit": habit}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// apiDeleteHabitHandler deletes a habit and its entries
+func (app *application) apiDeleteHabitHandler(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.habits.Delete(id, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "habit successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// apiListEntriesHandler returns every entry logged for a habit, newest first
+func (app *application) apiListEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	habit := app.apiOwnedHabit(w, r, app.authenticatedUserID(r))
+	if habit == nil {
+		return
+	}
+
+	entries, err := app.entries.GetByHabitID(habit.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if entries == nil {
+		entries = []data.HabitEntry{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"entries": entries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// apiLogEntryHandler records the entry for a day, replacing whatever was
+// logged before. Unlike the Done and Skip buttons it never toggles, so
+// scripts can safely retry. "amount" adds to a quantitative habit's total
+// instead, and "date" backfills a past day within the configured window.
+func (app *application) apiLogEntryHandler(w http.ResponseWriter, r *http.Request) {
+	habit := app.apiOwnedHabit(w, r, app.authenticatedUserID(r))
+	if habit == nil {
+		return
+	}
+
+	var input struct {
+		Date   string   `json:"date"`
+		Status string   `json:"status"`
+		Value  *float64 `json:"value"`
+		Amount *float64 `json:"amount"`
+		Notes  string   `json:"notes"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	entryDate := app.today(r)
+	if input.Date != "" {
+		entryDate, err = app.parseEntryDate(input.Date, entryDate)
+		if err != nil {
+			app.failedValidationResponse(w, r, map[string]string{"date": err.Error()})
+			return
+		}
+	}
+
+	entry := &data.HabitEntry{
+		HabitID:   habit.ID,
+		EntryDate: habit.PeriodStart(entryDate, app.weekStart),
+		Status:    input.Status,
+		Notes:     input.Notes,
+	}
+
+	v := validator.NewValidator()
+	if input.Amount != nil {
+		v.Check(habit.IsQuantitative(), "amount", "can only be logged for habits with a target")
+		v.Check(*input.Amount > 0, "amount", "must be greater than zero")
+		v.Check(*input.Amount < data.MaxValue, "amount", "must be less than 100000000")
+		v.Check(input.Status == "" && input.Value == nil, "amount", "can't be combined with status or value")
+		if !v.ValidData() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		entry.Value = *input.Amount
+		err = app.habits.AddAmount(entry, habit.TargetValue)
+	} else {
+		if entry.Status == "" {
+			entry.Status = "completed"
+		}
+		if input.Value != nil {
+			entry.Value = *input.Value
+		} else if entry.Status == "completed" && habit.IsQuantitative() {
+			entry.Value = habit.TargetValue
+		}
+
+		data.ValidateHabitEntry(v, entry)
+		if !v.ValidData() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = app.habits.LogEntry(entry)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.metrics.entriesLogged.Inc(entry.Status)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// apiProgressHandler returns the percentage of due habits completed in the
+// current period for each frequency, or just the one given by ?frequency=.
+func (app *application) apiProgressHandler(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	frequencies := data.PermittedFrequencies
+	if frequency := r.URL.Query().Get("frequency"); frequency != "" {
+		if !validator.PermittedValue(frequency, data.PermittedFrequencies...) {
+			app.failedValidationResponse(w, r, map[string]string{"frequency": "must be 'daily', 'weekly' or 'custom'"})
+			return
+		}
+		frequencies = []string{frequency}
+	}
+
+	today := app.today(r)
+	progress := make(map[string]int, len(frequencies))
+	for _, frequency := range frequencies {
+		_, percent, err := app.loadHabitStatuses(userID, frequency, today)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		progress[frequency] = percent
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"date": today.Format("2006-01-02"), "progress": progress}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// apiOwnedHabit is the JSON API's version of getOwnedHabit: it loads the habit
+// named by the {id} path value, or writes a JSON 404 and returns nil.
+func (app *application) apiOwnedHabit(w http.ResponseWriter, r *http.Request, userID int64) *data.Habit {
+	habitID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || habitID < 1 {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	habit, err := app.habits.GetByID(habitID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFoundResponse(w, r)
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+	if habit.UserID != userID {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+	return habit
+}
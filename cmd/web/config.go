@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+	"github.com/amari03/habit-tracker/internal/settings"
+)
+
+// envPrefix starts the environment variable for each flag, e.g. TRACKER_DB_DSN
+// for -db-dsn.
+const envPrefix = "TRACKER_"
+
+// config holds the app's settings, from flags, the environment or a config file.
+type config struct {
+	env         string // development or production
+	addr        string
+	metricsAddr string // empty to turn metrics off
+	baseURL     string
+	weekStart   time.Weekday
+
+	backfillDays int
+	autoMigrate  bool
+
+	trustedProxies []netip.Prefix // load balancers whose X-Forwarded-For is believed
+
+	db struct {
+		dsn          string
+		maxOpenConns int
+		maxIdleConns int
+		maxIdleTime  time.Duration
+		queryTimeout time.Duration
+	}
+
+	http struct {
+		readTimeout     time.Duration
+		writeTimeout    time.Duration
+		idleTimeout     time.Duration
+		shutdownTimeout time.Duration
+		shutdownDelay   time.Duration
+	}
+
+	tls struct {
+		certFile string
+		keyFile  string
+	}
+
+	session struct {
+		lifetime    time.Duration
+		idleTimeout time.Duration
+	}
+
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	mailDir string
+
+	limiter struct {
+		rps     float64
+		burst   int
+		enabled bool
+	}
+
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		name         string
+	}
+}
+
+// loadConfig reads the settings from args, the environment and the config
+// file, and checks them.
+func loadConfig(fs *flag.FlagSet, args []string) (config, error) {
+	var cfg config
+	var weekStart, trustedProxies string
+
+	fs.String("config", "", "Config file to read settings from (name = value lines)")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|production); production refuses development defaults")
+	fs.StringVar(&cfg.addr, "addr", ":4000", "HTTP network address")
+	fs.StringVar(&cfg.metricsAddr, "metrics-addr", "localhost:9464", "Network address to serve /metrics on over plain HTTP, kept off the public one (empty to turn it off)")
+	fs.StringVar(&cfg.baseURL, "base-url", "https://localhost:4000", "Public URL of the app, used for links in emails")
+	fs.StringVar(&weekStart, "week-start", "monday", "First day of the week for weekly habits")
+	fs.IntVar(&cfg.backfillDays, "backfill-days", 7, "How many days back entries can be logged")
+	fs.BoolVar(&cfg.autoMigrate, "auto-migrate", false, "Apply pending database migrations at startup instead of refusing to start")
+	fs.StringVar(&trustedProxies, "trusted-proxies", "", "Comma-separated addresses or CIDR ranges of the load balancers in front of the app, whose X-Forwarded-For header gives the client's IP")
+
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	// -dsn sets -db-dsn as though it was given itself, so on the command line
+	// it wins over the environment, but -db-dsn wins over it
+	var setByOldDSN bool
+	fs.Func("dsn", "Old name of -db-dsn, still accepted", func(value string) error {
+		dbDSNSet := false
+		fs.Visit(func(f *flag.Flag) { dbDSNSet = dbDSNSet || f.Name == "db-dsn" })
+		if dbDSNSet && !setByOldDSN {
+			return nil
+		}
+		setByOldDSN = true
+		return fs.Set("db-dsn", value)
+	})
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	fs.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
+	fs.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", data.DefaultTimeout, "How long a database query can take")
+
+	fs.DurationVar(&cfg.http.readTimeout, "http-read-timeout", 5*time.Second, "How long reading a request can take")
+	fs.DurationVar(&cfg.http.writeTimeout, "http-write-timeout", 10*time.Second, "How long writing a response can take")
+	fs.DurationVar(&cfg.http.idleTimeout, "http-idle-timeout", time.Minute, "How long to keep idle connections open")
+	fs.DurationVar(&cfg.http.shutdownTimeout, "shutdown-timeout", 30*time.Second, "How long to wait for requests and background work to finish when stopping")
+	fs.DurationVar(&cfg.http.shutdownDelay, "shutdown-delay", 0, "How long to keep serving with /readyz failing when stopping, so a load balancer stops sending requests first")
+
+	fs.StringVar(&cfg.tls.certFile, "tls-cert", "./tls/cert.pem", "TLS certificate file")
+	fs.StringVar(&cfg.tls.keyFile, "tls-key", "./tls/key.pem", "TLS private key file")
+
+	fs.DurationVar(&cfg.session.lifetime, "session-lifetime", 7*24*time.Hour, "How long a login lasts at most")
+	fs.DurationVar(&cfg.session.idleTimeout, "session-idle-timeout", 12*time.Hour, "How long a login lasts without being used")
+
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host (leave empty to log emails instead of sending them)")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 587, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "Habit Tracker <no-reply@habittracker.local>", "SMTP sender")
+	fs.StringVar(&cfg.mailDir, "mail-dir", "", "Directory to save logged emails to as .eml files")
+
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 10, "Requests per second allowed per IP")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 40, "Requests per IP allowed in a burst")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Rate limit requests per IP")
+
+	fs.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "OpenID Connect issuer URL for single sign-on (leave empty to turn it off)")
+	fs.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	fs.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", "", "OpenID Connect client secret (empty for a public client)")
+	fs.StringVar(&cfg.oidc.name, "oidc-name", "SSO", "Name of the identity provider on the login page")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] [migrate up|down|status]\n\n", fs.Name())
+		fmt.Fprintf(fs.Output(), "Each flag can also be set with a %s environment variable, e.g. %s.\n\n",
+			envPrefix+"*", settings.EnvName(envPrefix, "db-dsn"))
+		fs.PrintDefaults()
+	}
+
+	err := settings.Parse(fs, args, envPrefix, "config")
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.baseURL = strings.TrimSuffix(cfg.baseURL, "/")
+	cfg.weekStart, err = data.ParseWeekday(weekStart)
+	if err != nil {
+		return cfg, fmt.Errorf("week-start: %w", err)
+	}
+	cfg.trustedProxies, err = parsePrefixes(trustedProxies)
+	if err != nil {
+		return cfg, fmt.Errorf("trusted-proxies: %w", err)
+	}
+	return cfg, cfg.validate()
+}
+
+// validate checks the settings make sense, and in production that none of the
+// development defaults are left.
+func (cfg *config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.env == "development" || cfg.env == "production", "env must be development or production")
+	check(cfg.db.dsn != "", "db-dsn must be set")
+	check(cfg.backfillDays >= 0, "backfill-days must not be negative")
+	check(cfg.db.maxOpenConns >= 0 && cfg.db.maxIdleConns >= 0, "db-max-open-conns and db-max-idle-conns must not be negative")
+	check(cfg.db.queryTimeout > 0, "db-query-timeout must be more than zero")
+	check(cfg.http.readTimeout > 0 && cfg.http.writeTimeout > 0 && cfg.http.idleTimeout > 0, "the http timeouts must be more than zero")
+	check(cfg.http.shutdownTimeout > 0, "shutdown-timeout must be more than zero")
+	check(cfg.http.shutdownDelay >= 0, "shutdown-delay must not be negative")
+	check(cfg.session.lifetime > 0 && cfg.session.idleTimeout > 0, "session-lifetime and session-idle-timeout must be more than zero")
+	check(cfg.smtp.port > 0 && cfg.smtp.port < 65536, "smtp-port must be a port number")
+	check(!cfg.limiter.enabled || (cfg.limiter.rps > 0 && cfg.limiter.burst > 0), "limiter-rps and limiter-burst must be more than zero")
+	check(cfg.oidc.issuer == "" || cfg.oidc.clientID != "", "oidc-client-id must be set when oidc-issuer is")
+
+	baseURL, err := url.Parse(cfg.baseURL)
+	if err != nil {
+		baseURL = &url.URL{}
+	}
+	check(baseURL.Host != "" && (baseURL.Scheme == "https" || baseURL.Scheme == "http"), "base-url must be an http or https URL")
+
+	if cfg.env == "production" {
+		for _, file := range []string{cfg.tls.certFile, cfg.tls.keyFile} {
+			_, err := os.Stat(file)
+			check(err == nil, "TLS file %s can't be read: %v", file, err)
+		}
+		check(baseURL.Scheme == "https" && !isLocalhost(baseURL.Hostname()),
+			"base-url must be the app's public https URL in production, not %s", cfg.baseURL)
+		check(cfg.smtp.host != "", "smtp-host must be set in production, or emails are only logged")
+		check(cfg.limiter.enabled, "limiter-enabled can't be turned off in production")
+		if cfg.oidc.issuer != "" {
+			issuer, err := url.Parse(cfg.oidc.issuer)
+			check(err == nil && issuer.Scheme == "https" && !isLocalhost(issuer.Hostname()),
+				"oidc-issuer must be an https URL in production, not the mock identity provider")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// parsePrefixes parses a comma-separated list of CIDR ranges. A plain address
+// is a range of one.
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// isLocalhost reports whether host is this machine.
+func isLocalhost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
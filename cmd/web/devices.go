@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/amari03/habit-tracker/internal/sessions"
+)
+
+// Device is a session on the devices page, with its user agent summarised.
+type Device struct {
+	sessions.Device
+	Description string // e.g. "Firefox on Windows"
+}
+
+// devicesPage lists the user's sessions with buttons to log them out
+func (app *application) devicesPage(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	devices, err := app.session.Devices(r, userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Your Sessions"
+	templatePageData.IsAuthenticated = true
+	templatePageData.Flash = app.session.PopString(r, "flash")
+	for _, d := range devices {
+		templatePageData.Devices = append(templatePageData.Devices, Device{
+			Device:      d,
+			Description: describeUserAgent(d.UserAgent),
+		})
+	}
+
+	err = app.render(w, r, http.StatusOK, "devices.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// revokeDevice logs out one of the user's other sessions
+func (app *application) revokeDevice(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.session.Revoke(userID, id)
+	if err != nil {
+		if errors.Is(err, sessions.ErrNotFound) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.session.Put(r, "flash", "That session has been logged out.")
+	http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+}
+
+// revokeOtherDevices logs out every session of the user except this one
+func (app *application) revokeOtherDevices(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	err := app.session.RevokeOthers(r, userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "All your other sessions have been logged out.")
+	http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+}
+
+// describeUserAgent turns a User-Agent header into something like "Firefox on
+// Windows". It only knows the common browsers; anything else is "Unknown browser".
+func describeUserAgent(ua string) string {
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		// Order matters: Edge and Opera also claim to be Chrome, and Chrome claims to be Safari
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, os := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, os.token) {
+			return browser + " on " + os.name
+		}
+	}
+	return browser
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+	"github.com/amari03/habit-tracker/internal/validator"
+)
+
+// exportColumns is the header row of CSV exports. Each row is one entry, with
+// its habit's details repeated; habits with nothing logged get a single row
+// with the entry columns left blank.
+var exportColumns = []string{
+	"habit_id", "title", "description", "frequency", "goal", "times_per_week", "weekdays",
+	"target_value", "unit", "entry_date", "status", "value", "notes",
+}
+
+// exportHabit and exportEntry are the shapes written by JSON exports. Dates
+// are plain YYYY-MM-DD so the file is easy to use outside the tracker.
+type exportHabit struct {
+	ID           int64         `json:"id"`
+	Title        string        `json:"title"`
+	Description  string        `json:"description"`
+	Frequency    string        `json:"frequency"`
+	Goal         string        `json:"goal"`
+	TimesPerWeek int           `json:"times_per_week,omitempty"`
+	Weekdays     data.Weekdays `json:"weekdays,omitempty"`
+	TargetValue  float64       `json:"target_value,omitempty"`
+	Unit         string        `json:"unit,omitempty"`
+	CreatedAt    string        `json:"created_at"`
+}
+
+type exportEntry struct {
+	Date   string  `json:"date"`
+	Status string  `json:"status"`
+	Value  float64 `json:"value,omitempty"`
+	Notes  string  `json:"notes,omitempty"`
+}
+
+// exportPage shows the export form
+func (app *application) exportPage(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	habits, err := app.habits.GetAllByUser(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Export"
+	templatePageData.IsAuthenticated = true
+	templatePageData.Flash = app.session.PopString(r, "flash")
+	templatePageData.PermittedFrequencies = data.PermittedFrequencies
+	templatePageData.Habits = make([]*data.Habit, len(habits))
+	for i := range habits {
+		templatePageData.Habits[i] = &habits[i]
+	}
+
+	err = app.render(w, r, http.StatusOK, "export.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// exportHandler downloads the user's habits and entries as CSV or JSON
+func (app *application) exportHandler(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	format := r.PathValue("format")
+	if format != "csv" && format != "json" {
+		app.notFound(w)
+		return
+	}
+
+	filter, v := readExportFilter(r, userID)
+	if !v.ValidData() {
+		for field, message := range v.Errors {
+			app.session.Put(r, "flash", "Export failed: "+field+" "+message+".")
+			break
+		}
+		http.Redirect(w, r, "/export", http.StatusSeeOther)
+		return
+	}
+
+	app.writeExport(w, r, format, filter)
+}
+
+// apiExportHandler is the JSON API's version of exportHandler
+func (app *application) apiExportHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.PathValue("format")
+	if format != "csv" && format != "json" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	filter, v := readExportFilter(r, app.authenticatedUserID(r))
+	if !v.ValidData() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.writeExport(w, r, format, filter)
+}
+
+// readExportFilter reads the optional habit_id, frequency, from and to query
+// string parameters.
+func readExportFilter(r *http.Request, userID int64) (data.ExportFilter, *validator.Validator) {
+	qs := r.URL.Query()
+	v := validator.NewValidator()
+	filter := data.ExportFilter{
+		UserID:    userID,
+		Frequency: qs.Get("frequency"),
+	}
+
+	if value := qs.Get("habit_id"); value != "" {
+		habitID, err := strconv.ParseInt(value, 10, 64)
+		v.Check(err == nil && habitID > 0, "habit_id", "must be a habit ID")
+		filter.HabitID = habitID
+	}
+	for _, field := range []string{"from", "to"} {
+		value := qs.Get(field)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", value)
+		v.Check(err == nil, field, "must be a date in the form YYYY-MM-DD")
+		if field == "from" {
+			filter.From = date
+		} else {
+			filter.To = date
+		}
+	}
+
+	data.ValidateExportFilter(v, filter)
+	return filter, v
+}
+
+// writeExport streams the export as it is read from the database. Once the
+// first row has been written the status can't change any more, so an error
+// part-way through is only logged and the download ends early.
+func (app *application) writeExport(w http.ResponseWriter, r *http.Request, format string, filter data.ExportFilter) {
+	// Large exports take longer than the server's write timeout allows for a
+	// page; the query gives up after the same time
+	err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(data.ExportTimeout))
+	if err != nil {
+		app.logger.Warn("extending the write deadline for an export", "error", err)
+	}
+
+	filename := "habits-" + time.Now().Format("2006-01-02") + "." + format
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		err = app.writeCSVExport(r.Context(), w, filter)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		err = app.writeJSONExport(r.Context(), w, filter)
+	}
+	if err != nil {
+		app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	}
+}
+
+func (app *application) writeCSVExport(ctx context.Context, w http.ResponseWriter, filter data.ExportFilter) error {
+	cw := csv.NewWriter(w)
+
+	err := cw.Write(exportColumns)
+	if err != nil {
+		return err
+	}
+
+	err = app.habits.Export(ctx, filter, func(habit *data.Habit, entry *data.HabitEntry) error {
+		record := []string{
+			strconv.FormatInt(habit.ID, 10),
+			habit.Title,
+			habit.Description,
+			habit.Frequency,
+			habit.Goal,
+			formatTimesPerWeek(habit.TimesPerWeek),
+			habit.Weekdays.String(),
+			formatTargetValue(habit.TargetValue),
+			habit.Unit,
+			"", "", "", "",
+		}
+		if entry != nil {
+			record[9] = entry.EntryDate.Format("2006-01-02")
+			record[10] = entry.Status
+			record[11] = entry.FormattedValue()
+			record[12] = entry.Notes
+		}
+		return cw.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeJSONExport writes {"habits": [{..., "entries": [...]}, ...]} one habit
+// and one entry at a time, so only the current row is ever held in memory.
+func (app *application) writeJSONExport(ctx context.Context, w http.ResponseWriter, filter data.ExportFilter) error {
+	_, err := w.Write([]byte(`{"habits":[`))
+	if err != nil {
+		return err
+	}
+
+	var current int64
+	var entryCount int
+	err = app.habits.Export(ctx, filter, func(habit *data.Habit, entry *data.HabitEntry) error {
+		if habit.ID != current {
+			prefix := "\n"
+			if current != 0 {
+				prefix = "]},\n"
+			}
+			current = habit.ID
+			entryCount = 0
+
+			js, err := json.Marshal(newExportHabit(habit))
+			if err != nil {
+				return err
+			}
+			// Reopen the habit object to append its entries
+			js = append(js[:len(js)-1], []byte(`,"entries":[`)...)
+			_, err = w.Write(append([]byte(prefix), js...))
+			if err != nil {
+				return err
+			}
+		}
+
+		if entry == nil {
+			return nil
+		}
+
+		js, err := json.Marshal(exportEntry{
+			Date:   entry.EntryDate.Format("2006-01-02"),
+			Status: entry.Status,
+			Value:  entry.Value,
+			Notes:  entry.Notes,
+		})
+		if err != nil {
+			return err
+		}
+		if entryCount > 0 {
+			js = append([]byte(","), js...)
+		}
+		entryCount++
+		_, err = w.Write(js)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	closing := "]}\n"
+	if current != 0 {
+		closing = "]}\n]}\n"
+	}
+	_, err = w.Write([]byte(closing))
+	return err
+}
+
+func newExportHabit(habit *data.Habit) exportHabit {
+	return exportHabit{
+		ID:           habit.ID,
+		Title:        habit.Title,
+		Description:  habit.Description,
+		Frequency:    habit.Frequency,
+		Goal:         habit.Goal,
+		TimesPerWeek: habit.TimesPerWeek,
+		TargetValue:  habit.TargetValue,
+		Weekdays:     habit.Weekdays,
+		Unit:         habit.Unit,
+		CreatedAt:    habit.CreatedAt.Format("2006-01-02"),
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings" // Make sure this is imported
 	"time"
@@ -11,9 +14,13 @@ import (
 	"github.com/amari03/habit-tracker/internal/validator"
 )
 
-// authenticatedUserID returns the ID of the currently authenticated user from the session.
-// Returns 0 if no user is authenticated or if the ID is invalid.
+// authenticatedUserID returns the ID of the currently authenticated user from the
+// API token or the session. Returns 0 if no user is authenticated or if the ID is invalid.
 func (app *application) authenticatedUserID(r *http.Request) int64 {
+	if user := app.authenticatedUser(r); user != nil {
+		return user.ID
+	}
+
 	id, ok := app.session.Get(r, "authenticatedUserID").(int64)
 	if !ok {
 		return 0 // Or some other indicator for "not authenticated"
@@ -21,6 +28,28 @@ func (app *application) authenticatedUserID(r *http.Request) int64 {
 	return id
 }
 
+// authenticatedUser returns the logged-in user loaded by the authenticate
+// middleware, or nil if no user is authenticated.
+func (app *application) authenticatedUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(authenticatedUserContextKey).(*data.User)
+	if !ok {
+		return nil
+	}
+	return user
+}
+
+// today returns midnight of the current day for the logged-in user, taking
+// their time zone and day boundary into account. Anonymous requests fall back
+// to the server's local date.
+func (app *application) today(r *http.Request) time.Time {
+	now := time.Now()
+	if user := app.authenticatedUser(r); user != nil {
+		return user.Today(now)
+	}
+	y, m, d := now.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+}
+
 // homeHandler renders the home page
 func (app *application) homeHandler(w http.ResponseWriter, r *http.Request) {
 	userID := app.authenticatedUserID(r)
@@ -30,24 +59,70 @@ func (app *application) homeHandler(w http.ResponseWriter, r *http.Request) {
 	templatePageData.Title = "Home"
 	templatePageData.IsAuthenticated = true
 
-	// Fetch user details to get the name
-	if userID != 0 {
-		user, err := app.users.Get(userID) // Assuming app.users.Get(id) exists and returns (*data.User, error)
-		if err != nil {
-			// Log the error, but don't necessarily block the page from rendering.
-			// The welcome message will just be generic.
-			app.logger.Error("Failed to get user details for home page", "userID", userID, "error", err)
-		} else if user != nil {
-			templatePageData.UserName = user.Name
-		}
+	// The authenticate middleware has already loaded the user's details
+	if user := app.authenticatedUser(r); user != nil {
+		templatePageData.UserName = user.Name
+	}
+
+	// Show the user's habits with their streaks, best current streak first
+	habits, err := app.habits.GetAllByUser(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	habitPtrs := make([]*data.Habit, len(habits))
+	for i := range habits {
+		habitPtrs[i] = &habits[i]
+	}
+	err = app.loadStreaks(habitPtrs, app.today(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	sort.SliceStable(habitPtrs, func(i, j int) bool {
+		return habitPtrs[i].Streak.Current > habitPtrs[j].Streak.Current
+	})
+	templatePageData.Habits = habitPtrs
+
+	templatePageData.Stats, err = app.loadDashboard(userID, app.today(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
 	}
 
-	err := app.render(w, r, http.StatusOK, "home.tmpl", templatePageData)
+	err = app.render(w, r, http.StatusOK, "home.tmpl", templatePageData)
 	if err != nil {
 		app.serverError(w, r, err)
 	}
 }
 
+// loadDashboard runs the statistics queries for the home page dashboard
+func (app *application) loadDashboard(userID int64, today time.Time) (*Dashboard, error) {
+	var dashboard Dashboard
+	var err error
+
+	dashboard.CompletionRates, err = app.stats.CompletionRates(userID, today)
+	if err != nil {
+		return nil, err
+	}
+
+	dashboard.WeekdayScores, err = app.stats.WeekdayScores(userID, today)
+	if err != nil {
+		return nil, err
+	}
+	dashboard.BestWeekday, dashboard.WorstWeekday, dashboard.HasWeekdays = data.BestAndWorstWeekday(dashboard.WeekdayScores)
+
+	dashboard.DailyScores, err = app.stats.DailyScores(userID, today, 30)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(dashboard.DailyScores); n > 0 {
+		dashboard.Today = dashboard.DailyScores[n-1]
+	}
+
+	return &dashboard, nil
+}
+
 // landingHandler renders the landing page
 func (app *application) landingPageHandler(w http.ResponseWriter, r *http.Request) {
 	data := NewTemplateData()
@@ -59,7 +134,7 @@ func (app *application) landingPageHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
-// showHabitFormPageHandler renders the page with the habit creation form (daily.tmpl or weekly.tmpl)
+// showHabitFormPageHandler renders the page with the habit creation form (daily.tmpl, weekly.tmpl or custom.tmpl)
 func (app *application) showHabitFormPageHandler(w http.ResponseWriter, r *http.Request) {
 	userID := app.authenticatedUserID(r)
 	if userID == 0 {
@@ -68,22 +143,14 @@ func (app *application) showHabitFormPageHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	var frequency string
-	var templateName string
-
 	// Determine frequency from URL path
-	// Note: r.URL.Path will be like "/daily" or "/weekly"
-	switch r.URL.Path {
-	case "/daily":
-		frequency = "daily"
-		templateName = "daily.tmpl"
-	case "/weekly":
-		frequency = "weekly"
-		templateName = "weekly.tmpl"
-	default:
+	// Note: r.URL.Path will be like "/daily", "/weekly" or "/custom"
+	frequency, ok := frequencyFromPath(r.URL.Path)
+	if !ok {
 		app.notFound(w)
 		return
 	}
+	templateName := frequency + ".tmpl"
 
 	templatePageData := NewTemplateData()
 	templatePageData.Title = "Create " + frequency + " Habit"
@@ -107,44 +174,24 @@ func (app *application) showHabitEntriesPageHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	var frequency string
-	// Example path: /daily/entries or /weekly/entries
-	// We need to extract "daily" or "weekly"
-	pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-	if len(pathParts) < 2 || (pathParts[0] != "daily" && pathParts[0] != "weekly") || pathParts[1] != "entries" {
+	// Example path: /daily/entries, /weekly/entries or /custom/entries
+	frequency, ok := frequencyFromPath(r.URL.Path)
+	if !ok {
 		app.notFound(w)
 		return
 	}
-	frequency = pathParts[0]
 
-	habits, err := app.habits.GetAllByFrequency(userID, frequency)
+	today := app.today(r)
+	habitPtrs, currentProgress, err := app.loadHabitStatuses(userID, frequency, today)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	habitPtrs := make([]*data.Habit, len(habits))
-	today := time.Now().Format("2006-01-02")
-
-	var completedForProgress, totalForProgress int
-	for i := range habits {
-		habitPtrs[i] = &habits[i]
-		entries, entryErr := app.habits.GetEntries(habits[i].ID, time.Now(), time.Now()) // Get today's entries
-		if entryErr == nil && len(entries) > 0 {
-			// Assuming GetEntries orders by date desc, so first entry is the latest for the day
-			if entries[0].EntryDate.Format("2006-01-02") == today {
-				habitPtrs[i].TodayStatus = entries[0].Status
-				if entries[0].Status == "completed" {
-					completedForProgress++
-				}
-			}
-		}
-		totalForProgress++
-	}
-
-	currentProgress := 0
-	if totalForProgress > 0 {
-		currentProgress = (completedForProgress * 100) / totalForProgress
+	err = app.loadStreaks(habitPtrs, today)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
 	}
 
 	templatePageData := NewTemplateData()
@@ -154,6 +201,9 @@ func (app *application) showHabitEntriesPageHandler(w http.ResponseWriter, r *ht
 	templatePageData.IsAuthenticated = true
 	templatePageData.Progress = currentProgress // Initial progress for the page
 	templatePageData.Flash = app.session.PopString(r, "flash")
+	if frequency == "weekly" {
+		templatePageData.PeriodLabel = "Week of " + data.StartOfWeek(today, app.weekStart).Format("Mon 2 Jan 2006")
+	}
 
 	err = app.render(w, r, http.StatusOK, "entries.tmpl", templatePageData)
 	if err != nil {
@@ -184,30 +234,32 @@ func (app *application) createHabitHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	v := validator.NewValidator()
+	readHabitSchedule(r, habit, v)
+	readHabitTarget(r, habit, v)
 	data.ValidateHabit(v, habit)
 
 	if !v.ValidData() {
 		formTemplateData := NewTemplateData()
 		formTemplateData.FormErrors = v.Errors
 		formTemplateData.FormData = map[string]string{
-			"title":       habit.Title,
-			"description": habit.Description,
-			"goal":        habit.Goal,
+			"title":          habit.Title,
+			"description":    habit.Description,
+			"goal":           habit.Goal,
+			"times_per_week": r.FormValue("times_per_week"),
+			"target_value":   r.FormValue("target_value"),
+			"unit":           habit.Unit,
 		}
+		formTemplateData.WeekdayOptions = weekdayOptions(habit.Weekdays)
 		formTemplateData.Frequency = habit.Frequency
 		formTemplateData.IsAuthenticated = (userID != 0)
 		formTemplateData.Title = "Create " + habit.Frequency + " Habit - Error"
 
-		// Determine which template to re-render (daily.tmpl or weekly.tmpl)
-		var formTemplateName string
-		if habit.Frequency == "daily" {
-			formTemplateName = "daily.tmpl"
-		} else if habit.Frequency == "weekly" {
-			formTemplateName = "weekly.tmpl"
-		} else {
+		// Determine which template to re-render (daily.tmpl, weekly.tmpl or custom.tmpl)
+		if !validator.PermittedValue(habit.Frequency, data.PermittedFrequencies...) {
 			app.serverError(w, r, errors.New("invalid frequency on validation error"))
 			return
 		}
+		formTemplateName := habit.Frequency + ".tmpl"
 		err := app.render(w, r, http.StatusUnprocessableEntity, formTemplateName, formTemplateData)
 		if err != nil {
 			app.serverError(w, r, err)
@@ -233,7 +285,10 @@ func (app *application) createHabitHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
-// logEntryHandler records a habit completion/skip
+// logEntryHandler records a habit completion/skip. The Done and Skip buttons
+// act as toggles: choosing the status the entry already has clears it again,
+// and choosing the other one changes it. An optional entry_date backfills a
+// past day within the configured window.
 func (app *application) logEntryHandler(w http.ResponseWriter, r *http.Request) {
 	userID := app.authenticatedUserID(r)
 	if userID == 0 {
@@ -261,18 +316,145 @@ func (app *application) logEntryHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	entryDate := app.today(r)
+	backfill := strings.TrimSpace(r.FormValue("entry_date")) != ""
+	logged := true // false when a click undoes the entry
+	if backfill {
+		entryDate, err = app.parseEntryDate(r.FormValue("entry_date"), entryDate)
+		if err != nil {
+			app.session.Put(r, "flash", err.Error())
+			http.Redirect(w, r, "/habits/"+strconv.FormatInt(habitID, 10)+"/history", http.StatusSeeOther)
+			return
+		}
+	}
+
+	// Weekly habits are logged against the start of the week, so there is one
+	// entry per week rather than one per day.
 	entry := &data.HabitEntry{
 		HabitID:   habitID,
-		EntryDate: time.Now(),
+		EntryDate: habit.PeriodStart(entryDate, app.weekStart),
 		Status:    r.FormValue("status"),
 		Notes:     r.FormValue("notes"),
 	}
 
-	err = app.habits.LogEntry(entry)
+	if amount := strings.TrimSpace(r.FormValue("amount")); amount != "" && habit.IsQuantitative() {
+		// Incremental amount, e.g. another 0.5 L towards a 2 L target.
+		entry.Value, err = strconv.ParseFloat(amount, 64)
+		if err != nil || math.IsNaN(entry.Value) || math.IsInf(entry.Value, 0) || entry.Value <= 0 || entry.Value >= data.MaxValue {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		err = app.habits.AddAmount(entry, habit.TargetValue)
+	} else {
+		if entry.Status == "completed" && habit.IsQuantitative() {
+			entry.Value = habit.TargetValue
+		}
+
+		v := validator.NewValidator()
+		data.ValidateHabitEntry(v, entry)
+		if !v.ValidData() {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+
+		if backfill {
+			// Backfilled entries come from a form, so they simply replace
+			// whatever was logged for that day.
+			err = app.habits.LogEntry(entry)
+		} else {
+			logged, err = app.toggleEntry(entry)
+		}
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if logged {
+		app.metrics.entriesLogged.Inc(entry.Status)
+	}
+
+	redirectURL := "/" + habit.Frequency + "/entries"
+	if backfill {
+		app.session.Put(r, "flash", "Entry saved.")
+		redirectURL = "/habits/" + strconv.FormatInt(habitID, 10) + "/history"
+	}
+	if isHTMXRequest(r) {
+		w.Header().Set("HX-Redirect", redirectURL)
+		w.WriteHeader(http.StatusOK)
+	} else {
+		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+	}
+}
+
+// parseEntryDate parses a YYYY-MM-DD entry date, checking it isn't after the
+// user's today or further back than the backfill window allows.
+func (app *application) parseEntryDate(value string, today time.Time) (time.Time, error) {
+	date, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(value), today.Location())
+	if err != nil {
+		return time.Time{}, errors.New("Entry date must be a valid date.")
+	}
+
+	// Compare as YYYY-MM-DD strings so only the calendar date matters
+	if date.Format("2006-01-02") > today.Format("2006-01-02") {
+		return time.Time{}, errors.New("Entries can't be logged for future dates.")
+	}
+	if date.Format("2006-01-02") < today.AddDate(0, 0, -app.backfillDays).Format("2006-01-02") {
+		return time.Time{}, fmt.Errorf("Entries can only be logged for the last %d days.", app.backfillDays)
+	}
+	return date, nil
+}
+
+// toggleEntry saves the entry for its date. If an entry with the same status
+// already exists it is removed instead, undoing the earlier click, and logged
+// is false.
+func (app *application) toggleEntry(entry *data.HabitEntry) (logged bool, err error) {
+	existing, err := app.entries.GetByDate(entry.HabitID, entry.EntryDate)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return true, app.habits.LogEntry(entry)
+		}
+		return false, err
+	}
+
+	if existing.Status == entry.Status {
+		return false, app.entries.Delete(existing.ID)
+	}
+
+	existing.Status = entry.Status
+	existing.Value = entry.Value
+	if entry.Notes != "" {
+		existing.Notes = entry.Notes
+	}
+	return true, app.entries.Update(existing)
+}
+
+// clearEntryHandler undoes whatever was logged for a habit in the current
+// period (today, or this week for weekly habits).
+func (app *application) clearEntryHandler(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	habit := app.getOwnedHabit(w, r, userID)
+	if habit == nil {
+		return
+	}
+
+	today := app.today(r)
+	entries, err := app.habits.GetEntries(habit.ID, habit.PeriodStart(today, app.weekStart), today)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
+	for _, entry := range entries {
+		err = app.entries.Delete(entry.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
 
 	redirectURL := "/" + habit.Frequency + "/entries"
 	if isHTMXRequest(r) {
@@ -283,6 +465,220 @@ func (app *application) logEntryHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// showHabitHandler renders the detail page for a single habit: a year heatmap
+// of its entries, completion rate, streaks and the notes timeline
+func (app *application) showHabitHandler(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	habit := app.getOwnedHabit(w, r, userID)
+	if habit == nil {
+		return
+	}
+
+	entries, err := app.entries.GetByHabitID(habit.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	rate, err := app.entries.GetCompletionRate(habit.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	today := app.today(r)
+	habit.Streak = data.CalculateStreak(habit, entries, today, app.weekStart)
+
+	templatePageData := NewTemplateData()
+	templatePageData.Title = habit.Title
+	templatePageData.Habit = habit
+	templatePageData.Entries = entries
+	templatePageData.Frequency = habit.Frequency
+	templatePageData.IsAuthenticated = true
+	templatePageData.Flash = app.session.PopString(r, "flash")
+	templatePageData.CompletionRate = int(rate * 100)
+	templatePageData.Heatmap = newHeatmap(habit, entries, today, app.weekStart)
+
+	err = app.render(w, r, http.StatusOK, "habit.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// habitHistoryHandler lists every entry logged for a habit, with forms to
+// backfill past days and to edit or delete individual entries
+func (app *application) habitHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	habit := app.getOwnedHabit(w, r, userID)
+	if habit == nil {
+		return
+	}
+
+	entries, err := app.entries.GetByHabitID(habit.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	today := app.today(r)
+	templatePageData := NewTemplateData()
+	templatePageData.Title = habit.Title + " History"
+	templatePageData.Habit = habit
+	templatePageData.Entries = entries
+	templatePageData.Frequency = habit.Frequency
+	templatePageData.IsAuthenticated = true
+	templatePageData.Flash = app.session.PopString(r, "flash")
+	templatePageData.PermittedStatuses = data.PermittedEntryStatuses
+	templatePageData.MinEntryDate = today.AddDate(0, 0, -app.backfillDays).Format("2006-01-02")
+	templatePageData.MaxEntryDate = today.Format("2006-01-02")
+
+	err = app.render(w, r, http.StatusOK, "history.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// updateEntryHandler changes the status, amount or notes of a single entry
+func (app *application) updateEntryHandler(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	habit := app.getOwnedHabit(w, r, userID)
+	if habit == nil {
+		return
+	}
+	entry := app.getHabitEntry(w, r, habit)
+	if entry == nil {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	v := validator.NewValidator()
+	entry.Status = r.PostForm.Get("status")
+	entry.Notes = r.PostForm.Get("notes")
+	if value := strings.TrimSpace(r.PostForm.Get("value")); value != "" {
+		entry.Value, err = strconv.ParseFloat(value, 64)
+		v.Check(err == nil, "value", "must be a number")
+	}
+	data.ValidateHabitEntry(v, entry)
+
+	historyURL := "/habits/" + strconv.FormatInt(habit.ID, 10) + "/history"
+	if !v.ValidData() {
+		for field, message := range v.Errors {
+			app.session.Put(r, "flash", "Entry not saved: "+field+" "+message+".")
+			break
+		}
+		http.Redirect(w, r, historyURL, http.StatusSeeOther)
+		return
+	}
+
+	err = app.entries.Update(entry)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Entry updated.")
+	http.Redirect(w, r, historyURL, http.StatusSeeOther)
+}
+
+// deleteEntryHandler removes a single entry from a habit's history
+func (app *application) deleteEntryHandler(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	habit := app.getOwnedHabit(w, r, userID)
+	if habit == nil {
+		return
+	}
+	entry := app.getHabitEntry(w, r, habit)
+	if entry == nil {
+		return
+	}
+
+	err := app.entries.Delete(entry.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Entry deleted.")
+	http.Redirect(w, r, "/habits/"+strconv.FormatInt(habit.ID, 10)+"/history", http.StatusSeeOther)
+}
+
+// getOwnedHabit loads the habit named by the {id} path value. If the ID is
+// invalid or the habit doesn't belong to the user it writes the error
+// response and returns nil.
+func (app *application) getOwnedHabit(w http.ResponseWriter, r *http.Request, userID int64) *data.Habit {
+	habitID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return nil
+	}
+
+	habit, err := app.habits.GetByID(habitID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil
+	}
+	if habit.UserID != userID {
+		app.notFound(w)
+		return nil
+	}
+	return habit
+}
+
+// getHabitEntry loads the entry named by the {entryID} path value, checking
+// that it belongs to the given habit. On failure it writes the error response
+// and returns nil.
+func (app *application) getHabitEntry(w http.ResponseWriter, r *http.Request, habit *data.Habit) *data.HabitEntry {
+	entryID, err := strconv.ParseInt(r.PathValue("entryID"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return nil
+	}
+
+	entry, err := app.entries.Get(entryID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil
+	}
+	if entry.HabitID != habit.ID {
+		app.notFound(w)
+		return nil
+	}
+	return entry
+}
+
 // editHabitHandler shows the edit form if the habit belongs to the user
 func (app *application) editHabitHandler(w http.ResponseWriter, r *http.Request) {
 	userID := app.authenticatedUserID(r)
@@ -298,7 +694,7 @@ func (app *application) editHabitHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if frequencyPathValue != "daily" && frequencyPathValue != "weekly" {
+	if !validator.PermittedValue(frequencyPathValue, data.PermittedFrequencies...) {
 		app.notFound(w)
 		return
 	}
@@ -322,14 +718,18 @@ func (app *application) editHabitHandler(w http.ResponseWriter, r *http.Request)
 	editTemplateData.Title = "Edit Habit"
 	editTemplateData.Habit = habit
 	editTemplateData.Frequency = frequencyPathValue
-	editTemplateData.PermittedFrequencies = []string{"daily", "weekly"}
+	editTemplateData.PermittedFrequencies = data.PermittedFrequencies
 	editTemplateData.IsAuthenticated = true
 	editTemplateData.FormData = map[string]string{
-		"title":       habit.Title,
-		"description": habit.Description,
-		"frequency":   habit.Frequency,
-		"goal":        habit.Goal,
+		"title":          habit.Title,
+		"description":    habit.Description,
+		"frequency":      habit.Frequency,
+		"goal":           habit.Goal,
+		"times_per_week": formatTimesPerWeek(habit.TimesPerWeek),
+		"target_value":   formatTargetValue(habit.TargetValue),
+		"unit":           habit.Unit,
 	}
+	editTemplateData.WeekdayOptions = weekdayOptions(habit.Weekdays)
 
 	err = app.render(w, r, http.StatusOK, "edit.tmpl", editTemplateData)
 	if err != nil {
@@ -382,6 +782,8 @@ func (app *application) updateHabitHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	v := validator.NewValidator()
+	readHabitSchedule(r, habitToUpdate, v)
+	readHabitTarget(r, habitToUpdate, v)
 	data.ValidateHabit(v, habitToUpdate)
 	if !v.ValidData() {
 		errorTemplateData := NewTemplateData()
@@ -389,14 +791,18 @@ func (app *application) updateHabitHandler(w http.ResponseWriter, r *http.Reques
 		errorTemplateData.FormErrors = v.Errors
 		errorTemplateData.Habit = habitToUpdate
 		errorTemplateData.Frequency = frequencyPathValue
-		errorTemplateData.PermittedFrequencies = []string{"daily", "weekly"}
+		errorTemplateData.PermittedFrequencies = data.PermittedFrequencies
 		errorTemplateData.IsAuthenticated = true
 		errorTemplateData.FormData = map[string]string{
-			"title":       habitToUpdate.Title,
-			"description": habitToUpdate.Description,
-			"frequency":   habitToUpdate.Frequency,
-			"goal":        habitToUpdate.Goal,
+			"title":          habitToUpdate.Title,
+			"description":    habitToUpdate.Description,
+			"frequency":      habitToUpdate.Frequency,
+			"goal":           habitToUpdate.Goal,
+			"times_per_week": r.FormValue("times_per_week"),
+			"target_value":   r.FormValue("target_value"),
+			"unit":           habitToUpdate.Unit,
 		}
+		errorTemplateData.WeekdayOptions = weekdayOptions(habitToUpdate.Weekdays)
 		err = app.render(w, r, http.StatusUnprocessableEntity, "edit.tmpl", errorTemplateData)
 		if err != nil {
 			app.serverError(w, r, err)
@@ -432,7 +838,7 @@ func (app *application) deleteHabitHandler(w http.ResponseWriter, r *http.Reques
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
-	if frequency != "daily" && frequency != "weekly" {
+	if !validator.PermittedValue(frequency, data.PermittedFrequencies...) {
 		app.notFound(w)
 		return
 	}
@@ -475,41 +881,164 @@ func (app *application) progressHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	frequency := r.PathValue("frequency")
-	if frequency != "daily" && frequency != "weekly" {
+	// Example path: /daily/progress, /weekly/progress or /custom/progress
+	frequency, ok := frequencyFromPath(r.URL.Path)
+	if !ok {
 		app.notFound(w)
 		return
 	}
 
-	habits, err := app.habits.GetAllByFrequency(userID, frequency)
+	_, progress, err := app.loadHabitStatuses(userID, frequency, app.today(r))
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	var completed, total int
-	today := time.Now().Format("2006-01-02")
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(`<div class="progress-bar" style="width: ` + strconv.Itoa(progress) + `%;">` + strconv.Itoa(progress) + `%</div>`))
+}
 
-	for _, habit := range habits {
-		entries, err := app.habits.GetEntries(habit.ID, time.Now(), time.Now())
-		if err == nil && len(entries) > 0 {
-			for _, entry := range entries {
-				if entry.EntryDate.Format("2006-01-02") == today && entry.Status == "completed" {
-					completed++
-					break
+// loadHabitStatuses fetches the user's habits of the given frequency, fills in
+// their status for the current period (today, or this week for weekly habits)
+// and returns the percentage of due habits that have been completed. Custom
+// habits that aren't scheduled today don't count, and quantitative habits
+// count partially (1.2 of 2 L adds 0.6 of a habit).
+func (app *application) loadHabitStatuses(userID int64, frequency string, today time.Time) ([]*data.Habit, int, error) {
+	habits, err := app.habits.GetAllByFrequency(userID, frequency)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	weekStart := data.StartOfWeek(today, app.weekStart)
+
+	habitPtrs := make([]*data.Habit, len(habits))
+	var completed float64
+	var total int
+	for i := range habits {
+		habit := &habits[i]
+		habitPtrs[i] = habit
+
+		entries, err := app.habits.GetEntries(habit.ID, weekStart, today)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// Weekly habits aggregate every entry since the start of the week, so
+		// an entry logged on Monday still counts on Tuesday.
+		periodStart := habit.PeriodStart(today, app.weekStart).Format("2006-01-02")
+		for _, entry := range entries {
+			if entry.EntryDate.Format("2006-01-02") >= periodStart {
+				habit.TodayValue += entry.Value
+				if habit.TodayStatus != "completed" {
+					habit.TodayStatus = entry.Status
 				}
 			}
+			if entry.Status == "completed" {
+				habit.CompletedThisWeek++
+			}
+		}
+		if habit.IsQuantitative() && habit.Completion(habit.TodayValue) >= 1 {
+			habit.TodayStatus = "completed"
+		}
+
+		habit.DueToday = habit.DueOn(today)
+		if habit.TimesPerWeek > 0 {
+			// Once this week's target is met the habit isn't due again until
+			// next week, unless one of the completions was logged today.
+			habit.DueToday = habit.CompletedThisWeek < habit.TimesPerWeek || habit.TodayStatus == "completed"
+		}
+
+		if habit.DueToday {
+			total++
+			if habit.TodayStatus == "completed" {
+				completed++
+			} else if habit.IsQuantitative() {
+				completed += habit.Completion(habit.TodayValue)
+			}
 		}
-		total++
 	}
 
 	progress := 0
 	if total > 0 {
-		progress = (completed * 100) / total
+		progress = int(completed * 100 / float64(total))
 	}
+	return habitPtrs, progress, nil
+}
 
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(`<div class="progress-bar" style="width: ` + strconv.Itoa(progress) + `%;">` + strconv.Itoa(progress) + `%</div>`))
+// loadStreaks calculates the current and longest streak of each habit using a
+// single query for all of their entries.
+func (app *application) loadStreaks(habits []*data.Habit, today time.Time) error {
+	if len(habits) == 0 {
+		return nil
+	}
+
+	habitIDs := make([]int64, len(habits))
+	for i, habit := range habits {
+		habitIDs[i] = habit.ID
+	}
+
+	entries, err := app.entries.GetByHabitIDs(habitIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, habit := range habits {
+		habit.Streak = data.CalculateStreak(habit, entries[habit.ID], today, app.weekStart)
+	}
+	return nil
+}
+
+// readHabitSchedule fills in the schedule of a custom habit from the submitted
+// form. Other frequencies ignore the schedule fields.
+func readHabitSchedule(r *http.Request, habit *data.Habit, v *validator.Validator) {
+	if habit.Frequency != "custom" {
+		return
+	}
+
+	if value := strings.TrimSpace(r.FormValue("times_per_week")); value != "" {
+		timesPerWeek, err := strconv.Atoi(value)
+		v.Check(err == nil, "times_per_week", "must be a whole number")
+		habit.TimesPerWeek = timesPerWeek
+	}
+
+	weekdays, err := data.ParseWeekdays(r.Form["weekdays"])
+	v.Check(err == nil, "weekdays", "contains an unknown day")
+	habit.Weekdays = weekdays
+}
+
+// readHabitTarget fills in the numeric target and unit of a quantitative habit
+// from the submitted form. A blank target means the habit isn't quantitative.
+func readHabitTarget(r *http.Request, habit *data.Habit, v *validator.Validator) {
+	habit.Unit = strings.TrimSpace(r.FormValue("unit"))
+
+	if value := strings.TrimSpace(r.FormValue("target_value")); value != "" {
+		targetValue, err := strconv.ParseFloat(value, 64)
+		v.Check(err == nil, "target_value", "must be a number")
+		habit.TargetValue = targetValue
+	}
+}
+
+// formatTargetValue renders the target form value, leaving it blank when unused.
+func formatTargetValue(targetValue float64) string {
+	if targetValue == 0 {
+		return ""
+	}
+	return data.FormatAmount(targetValue)
+}
+
+// formatTimesPerWeek renders the times-per-week form value, leaving it blank when unused.
+func formatTimesPerWeek(timesPerWeek int) string {
+	if timesPerWeek == 0 {
+		return ""
+	}
+	return strconv.Itoa(timesPerWeek)
+}
+
+// frequencyFromPath extracts the frequency from the first segment of paths
+// like "/daily", "/weekly/entries" or "/custom/progress".
+func frequencyFromPath(path string) (string, bool) {
+	frequency, _, _ := strings.Cut(strings.Trim(path, "/"), "/")
+	return frequency, validator.PermittedValue(frequency, data.PermittedFrequencies...)
 }
 
 func (app *application) signupUserForm(w http.ResponseWriter, r *http.Request) {
@@ -538,9 +1067,7 @@ func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
 		Email: email,
 	}
 	data.ValidateUser(v, user)
-	v.Check(validator.NotBlank(passwordInput), "password", "Password must be provided")
-	v.Check(validator.MinLength(passwordInput, 8), "password", "Password must be at least 8 characters long")
-	v.Check(validator.MaxLength(passwordInput, 72), "password", "Password must not be more than 72 characters")
+	data.ValidatePasswordPlaintext(v, passwordInput)
 
 	if !v.ValidData() {
 		formData := NewTemplateData()
@@ -562,7 +1089,15 @@ func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
 		app.serverError(w, r, err)
 		return
 	}
-	user.Active = true
+	user.HasPassword = true
+	user.Active = false // until the link in the activation email is followed
+
+	// The signup form fills in the browser's time zone; fall back to UTC if
+	// it's missing or unknown, the user can change it later.
+	user.Timezone = r.PostForm.Get("timezone")
+	if !data.ValidTimezone(user.Timezone) {
+		user.Timezone = "UTC"
+	}
 
 	err = app.users.Insert(user)
 	if err != nil {
@@ -585,7 +1120,15 @@ func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	app.session.Put(r, "flash", "Your signup was successful! Please log in.")
+	app.metrics.signups.Inc("password")
+
+	err = app.sendActivationEmail(user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your signup was successful! We've sent an activation link to "+user.Email+", follow it to log in.")
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
@@ -593,6 +1136,7 @@ func (app *application) loginUserForm(w http.ResponseWriter, r *http.Request) {
 	data := NewTemplateData()
 	data.Title = "Login"
 	data.Flash = app.session.PopString(r, "flash")
+	data.SSOName = app.ssoName
 	err := app.render(w, r, http.StatusOK, "login.tmpl", data)
 	if err != nil {
 		app.serverError(w, r, err)
@@ -615,42 +1159,320 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !v.ValidData() {
-		data := NewTemplateData()
-		data.Title = "Login - Error"
-		data.FormData = map[string]string{"email": email}
-		data.FormErrors = v.Errors
-		errRender := app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl", data)
-		if errRender != nil {
-			app.serverError(w, r, errRender)
-		}
+		app.renderLoginError(w, r, http.StatusUnprocessableEntity, email, v.Errors)
+		return
+	}
+
+	// Refuse locked out logins before bcrypt gets a chance to run
+	attempt, wait := app.startLoginAttempt(app.clientIP(r), strings.ToLower(strings.TrimSpace(email)))
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		v.AddError("throttled", "Too many failed login attempts. Please wait "+humanDuration(wait)+" before trying again.")
+		app.renderLoginError(w, r, http.StatusTooManyRequests, email, v.Errors)
 		return
 	}
 
 	id, err := app.users.Authenticate(email, passwordInput)
 	if err != nil {
-		if errors.Is(err, data.ErrInvalidCredentials) {
-			v.AddError("generic", "Invalid email or password.")
-			data := NewTemplateData()
-			data.Title = "Login - Error"
-			data.FormData = map[string]string{"email": email}
-			data.FormErrors = v.Errors
-			errRender := app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl", data)
-			if errRender != nil {
-				app.serverError(w, r, errRender)
-			}
-		} else {
+		switch {
+		case errors.Is(err, data.ErrNotActivated):
+			app.releaseLoginAttempt(attempt)
+			v.AddError("activation", "Your account hasn't been activated yet. Follow the link in the email we sent you.")
+			app.renderLoginError(w, r, http.StatusUnprocessableEntity, email, v.Errors)
+		case errors.Is(err, data.ErrInvalidCredentials):
+			app.loginFailed(v, attempt, "Invalid email or password.")
+			app.renderLoginError(w, r, http.StatusUnprocessableEntity, email, v.Errors)
+		default:
+			app.releaseLoginAttempt(attempt)
 			app.serverError(w, r, err)
 		}
 		return
 	}
 
-	app.session.Put(r, "authenticatedUserID", id)
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.releaseLoginAttempt(attempt)
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The password was right, but failures are only forgotten once the
+	// second step passes too, so retrying the password can't reset them.
+	if user.TwoFactorEnabled() {
+		app.releaseLoginAttempt(attempt)
+		app.startTwoFactorLogin(r, user)
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+	app.loginSucceeded(attempt)
+
+	app.logIn(r, user)
 	app.session.Put(r, "flash", "You have been logged in successfully!")
 	http.Redirect(w, r, "/apphome", http.StatusSeeOther)
 }
 
+// logIn starts an authenticated session for the user, with a new token so one
+// planted beforehand can't be used to ride the session.
+func (app *application) logIn(r *http.Request, user *data.User) {
+	app.session.RenewToken(r)
+	app.session.Put(r, "authenticatedUserID", user.ID)
+	app.session.Put(r, "sessionVersion", user.SessionVersion)
+	app.metrics.logins.Inc("success")
+}
+
+// loginAttempts is a login counted against both the IP and the account until
+// it's known not to have failed.
+type loginAttempts struct {
+	ip, account  loginAttempt
+	attemptsLeft int
+}
+
+// startLoginAttempt counts a login against the IP and the account before the
+// password or code is checked. If either is locked out nothing is counted and
+// wait is how long until logins may be tried again.
+func (app *application) startLoginAttempt(ipKey, accountKey string) (attempt *loginAttempts, wait time.Duration) {
+	ip, ipLeft, wait := app.loginsByIP.attempt(ipKey)
+	if wait > 0 {
+		return nil, wait
+	}
+	account, accountLeft, wait := app.loginsByAccount.attempt(accountKey)
+	if wait > 0 {
+		app.loginsByIP.release(ip)
+		return nil, wait
+	}
+	return &loginAttempts{ip: ip, account: account, attemptsLeft: min(ipLeft, accountLeft)}, 0
+}
+
+// releaseLoginAttempt uncounts a login that didn't fail, e.g. because the
+// password was right but the second step is still to come.
+func (app *application) releaseLoginAttempt(attempt *loginAttempts) {
+	app.loginsByIP.release(attempt.ip)
+	app.loginsByAccount.release(attempt.account)
+}
+
+// loginSucceeded uncounts a successful login and forgets the account's
+// failures.
+func (app *application) loginSucceeded(attempt *loginAttempts) {
+	app.loginsByIP.release(attempt.ip)
+	app.loginsByAccount.succeed(attempt.account.key)
+}
+
+// loginFailed adds message to the form errors for a login already counted as
+// failed, warning when the lockouts are about to start.
+func (app *application) loginFailed(v *validator.Validator, attempt *loginAttempts, message string) {
+	app.metrics.logins.Inc("failure")
+
+	// The failure after the last free one starts the lockouts
+	attemptsLeft := attempt.attemptsLeft + 1
+	wait := max(app.loginsByIP.wait(attempt.ip.key), app.loginsByAccount.wait(attempt.account.key))
+
+	switch {
+	case wait > 0:
+		v.AddError("throttled", message+" Too many failed attempts, please wait "+humanDuration(wait)+" before trying again.")
+	case attemptsLeft == 1:
+		v.AddError("generic", message+" One more failed attempt will pause logins for a while.")
+	case attemptsLeft == 2:
+		v.AddError("generic", message+" Two more failed attempts will pause logins for a while.")
+	default:
+		v.AddError("generic", message)
+	}
+}
+
+// renderLoginError shows the login form again with an error
+func (app *application) renderLoginError(w http.ResponseWriter, r *http.Request, status int, email string, formErrors map[string]string) {
+	data := NewTemplateData()
+	data.Title = "Login - Error"
+	data.SSOName = app.ssoName
+	data.FormData = map[string]string{"email": email}
+	data.FormErrors = formErrors
+	err := app.render(w, r, status, "login.tmpl", data)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// userPreferencesForm shows the time zone and day boundary settings
+func (app *application) userPreferencesForm(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Preferences"
+	templatePageData.IsAuthenticated = true
+	templatePageData.Flash = app.session.PopString(r, "flash")
+	templatePageData.FormData = map[string]string{
+		"timezone":       user.Timezone,
+		"day_start_hour": strconv.Itoa(user.DayStartHour),
+	}
+
+	err := app.render(w, r, http.StatusOK, "preferences.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// userPreferences saves the user's time zone and day boundary
+func (app *application) userPreferences(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	v := validator.NewValidator()
+	user.Timezone = strings.TrimSpace(r.PostForm.Get("timezone"))
+	user.DayStartHour, err = strconv.Atoi(r.PostForm.Get("day_start_hour"))
+	v.Check(err == nil, "day_start_hour", "must be a whole number")
+	data.ValidatePreferences(v, user)
+
+	if !v.ValidData() {
+		templatePageData := NewTemplateData()
+		templatePageData.Title = "Preferences - Error"
+		templatePageData.IsAuthenticated = true
+		templatePageData.FormErrors = v.Errors
+		templatePageData.FormData = map[string]string{
+			"timezone":       user.Timezone,
+			"day_start_hour": r.PostForm.Get("day_start_hour"),
+		}
+		err = app.render(w, r, http.StatusUnprocessableEntity, "preferences.tmpl", templatePageData)
+		if err != nil {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.users.Update(user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Preferences saved.")
+	http.Redirect(w, r, "/user/preferences", http.StatusSeeOther)
+}
+
+// apiTokensPage lists the user's API tokens with a form to create a new one
+func (app *application) apiTokensPage(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "API Tokens"
+	templatePageData.IsAuthenticated = true
+	templatePageData.Flash = app.session.PopString(r, "flash")
+	templatePageData.FormData = map[string]string{"scope": data.ScopeRead, "expires_in": "90"}
+
+	app.renderAPITokens(w, r, http.StatusOK, userID, templatePageData)
+}
+
+// createAPIToken creates a token and shows its plaintext. This is the only
+// time it is shown, so the page is rendered directly instead of redirecting.
+func (app *application) createAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	token := &data.APIToken{
+		Name:  strings.TrimSpace(r.PostForm.Get("name")),
+		Scope: r.PostForm.Get("scope"),
+	}
+
+	v := validator.NewValidator()
+	data.ValidateAPIToken(v, token)
+	expiresIn, err := strconv.Atoi(r.PostForm.Get("expires_in"))
+	v.Check(err == nil && validator.PermittedValue(expiresIn, 0, 30, 90, 365), "expires_in", "must be one of the listed options")
+
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "API Tokens"
+	templatePageData.IsAuthenticated = true
+
+	if !v.ValidData() {
+		templatePageData.FormErrors = v.Errors
+		templatePageData.FormData = map[string]string{
+			"name":       token.Name,
+			"scope":      token.Scope,
+			"expires_in": r.PostForm.Get("expires_in"),
+		}
+		app.renderAPITokens(w, r, http.StatusUnprocessableEntity, userID, templatePageData)
+		return
+	}
+
+	templatePageData.NewAPIToken, err = app.tokens.New(userID, token.Name, token.Scope, time.Duration(expiresIn)*24*time.Hour)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	templatePageData.FormData = map[string]string{"scope": data.ScopeRead, "expires_in": "90"}
+
+	app.renderAPITokens(w, r, http.StatusOK, userID, templatePageData)
+}
+
+// revokeAPIToken deletes one of the user's tokens
+func (app *application) revokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.tokens.Delete(id, userID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.session.Put(r, "flash", "Token revoked.")
+	http.Redirect(w, r, "/user/tokens", http.StatusSeeOther)
+}
+
+// renderAPITokens loads the user's tokens into the template data and renders the tokens page
+func (app *application) renderAPITokens(w http.ResponseWriter, r *http.Request, status int, userID int64, templatePageData *TemplateData) {
+	tokens, err := app.tokens.GetAllForUser(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	templatePageData.APITokens = tokens
+	templatePageData.PermittedScopes = data.PermittedScopes
+
+	err = app.render(w, r, status, "tokens.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
 func (app *application) logoutUserHandler(w http.ResponseWriter, r *http.Request) {
-	app.session.Remove(r, "authenticatedUserID")
+	app.session.Destroy(r)
 	app.session.Put(r, "flash", "You have been logged out successfully.")
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+)
+
+// Layout of the year heatmap, in SVG user units
+const (
+	heatmapCellSize  = 11
+	heatmapCellStep  = 13 // cell size plus gap
+	heatmapLeftPad   = 30 // room for weekday labels
+	heatmapTopPad    = 15 // room for month labels
+	heatmapWeekCount = 53
+)
+
+// Heatmap is a GitHub-style grid of the last year's entries, one column per
+// week and one row per weekday, rendered as SVG by the habit detail page.
+type Heatmap struct {
+	Width         int
+	Height        int
+	Cells         []HeatmapCell
+	MonthLabels   []HeatmapLabel
+	WeekdayLabels []HeatmapLabel
+}
+
+// HeatmapCell is a single day. Level goes from 0 (nothing logged) to 4
+// (completed) and picks the cell's colour.
+type HeatmapCell struct {
+	X     int
+	Y     int
+	Level int
+	Title string
+}
+
+// HeatmapLabel is a month or weekday label around the grid.
+type HeatmapLabel struct {
+	X    int
+	Y    int
+	Text string
+}
+
+// newHeatmap lays out the year ending on today. Weeks start on weekStart.
+func newHeatmap(habit *data.Habit, entries []data.HabitEntry, today time.Time, weekStart time.Weekday) *Heatmap {
+	byDate := make(map[string]data.HabitEntry, len(entries))
+	for _, entry := range entries {
+		byDate[entry.EntryDate.Format("2006-01-02")] = entry
+	}
+
+	first := data.StartOfWeek(today, weekStart).AddDate(0, 0, -7*(heatmapWeekCount-1))
+	heatmap := &Heatmap{
+		Width:  heatmapLeftPad + heatmapWeekCount*heatmapCellStep,
+		Height: heatmapTopPad + 7*heatmapCellStep,
+	}
+
+	for row := 0; row < 7; row += 2 {
+		heatmap.WeekdayLabels = append(heatmap.WeekdayLabels, HeatmapLabel{
+			X:    0,
+			Y:    heatmapTopPad + row*heatmapCellStep + heatmapCellSize - 1,
+			Text: first.AddDate(0, 0, row).Format("Mon"),
+		})
+	}
+
+	lastMonth := time.Month(0)
+	for week := 0; week < heatmapWeekCount; week++ {
+		weekDate := first.AddDate(0, 0, 7*week)
+		if weekDate.Month() != lastMonth {
+			lastMonth = weekDate.Month()
+			// Skip a label squeezed into the first column of a partial month
+			if week > 0 || weekDate.Day() <= 7 {
+				heatmap.MonthLabels = append(heatmap.MonthLabels, HeatmapLabel{
+					X:    heatmapLeftPad + week*heatmapCellStep,
+					Y:    heatmapTopPad - 4,
+					Text: weekDate.Format("Jan"),
+				})
+			}
+		}
+
+		for row := 0; row < 7; row++ {
+			date := weekDate.AddDate(0, 0, row)
+			if date.After(today) {
+				break
+			}
+
+			title := date.Format("Mon 2 Jan 2006") + ": nothing logged"
+			level := 0
+			if entry, ok := byDate[date.Format("2006-01-02")]; ok {
+				level = heatmapLevel(habit, entry)
+				title = date.Format("Mon 2 Jan 2006") + ": " + entry.Status
+				if habit.IsQuantitative() {
+					title += " (" + entry.FormattedValue() + " " + habit.Unit + ")"
+				}
+			}
+
+			heatmap.Cells = append(heatmap.Cells, HeatmapCell{
+				X:     heatmapLeftPad + week*heatmapCellStep,
+				Y:     heatmapTopPad + row*heatmapCellStep,
+				Level: level,
+				Title: title,
+			})
+		}
+	}
+
+	return heatmap
+}
+
+// heatmapLevel maps an entry onto a colour level: skipped days are faint,
+// partial amounts are shaded by how close they got to the target.
+func heatmapLevel(habit *data.Habit, entry data.HabitEntry) int {
+	switch entry.Status {
+	case "completed":
+		return 4
+	case "skipped":
+		return 1
+	case "partial":
+		if habit.Completion(entry.Value) >= 0.5 {
+			return 3
+		}
+		return 2
+	default:
+		return 0
+	}
+}
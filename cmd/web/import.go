@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+	"github.com/amari03/habit-tracker/internal/importer"
+	"github.com/amari03/habit-tracker/internal/validator"
+)
+
+// maxImportSize limits the size of uploaded export files
+const maxImportSize = 10 << 20
+
+// pendingImportTTL is how long a previewed import waits to be confirmed
+const pendingImportTTL = 30 * time.Minute
+
+// pendingImports holds parsed uploads between the preview and the commit, so
+// the file doesn't have to be uploaded twice. They only live in memory: after
+// a restart the user simply uploads the file again.
+type pendingImports struct {
+	mu    sync.Mutex
+	items map[string]pendingImport
+}
+
+type pendingImport struct {
+	userID  int64
+	habits  []data.ImportedHabit
+	expires time.Time
+}
+
+func newPendingImports() *pendingImports {
+	return &pendingImports{items: make(map[string]pendingImport)}
+}
+
+// put stores a parsed import and returns the ID used to confirm it
+func (p *pendingImports) put(userID int64, habits []data.ImportedHabit) (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for key, item := range p.items {
+		if now.After(item.expires) {
+			delete(p.items, key)
+		}
+	}
+	p.items[id] = pendingImport{userID: userID, habits: habits, expires: now.Add(pendingImportTTL)}
+	return id, nil
+}
+
+// take removes and returns the user's pending import with the given ID
+func (p *pendingImports) take(id string, userID int64) ([]data.ImportedHabit, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	item, ok := p.items[id]
+	if !ok || item.userID != userID || time.Now().After(item.expires) {
+		return nil, false
+	}
+	delete(p.items, id)
+	return item.habits, true
+}
+
+// limitImportSize caps the size of uploads to the import form. It has to run
+// before nosurf, which parses the whole form looking for the CSRF token before
+// the handler gets a chance to. Uploads that are too big by their length are
+// sent back to the form with a message; ones without a length fail the CSRF
+// check once the cap cuts them off.
+func (app *application) limitImportSize(next http.Handler) http.Handler {
+	const limit = maxImportSize + 1<<20 // room for the other fields and the multipart framing
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/import" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > limit {
+			app.session.Put(r, "flash", "The file must be smaller than 10 MB.")
+			http.Redirect(w, r, "/import", http.StatusSeeOther)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// importPage shows the upload form
+func (app *application) importPage(w http.ResponseWriter, r *http.Request) {
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Import"
+	templatePageData.IsAuthenticated = true
+	templatePageData.Flash = app.session.PopString(r, "flash")
+	templatePageData.ImportFormats = importer.PermittedFormats
+	templatePageData.FormData = map[string]string{"format": importer.FormatAuto}
+
+	err := app.render(w, r, http.StatusOK, "import.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// importPreview parses the uploaded file and shows what importing it would do,
+// without saving anything
+func (app *application) importPreview(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	// The body was capped by limitImportSize, before nosurf read it
+	err := r.ParseMultipartForm(maxImportSize)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.renderImportError(w, r, "file", "must be smaller than 10 MB")
+		} else {
+			app.renderImportError(w, r, "file", "couldn't be uploaded, please try again")
+		}
+		return
+	}
+
+	format := r.PostForm.Get("format")
+	if !validator.PermittedValue(format, importer.PermittedFormats...) {
+		app.renderImportError(w, r, "format", "must be one of the listed formats")
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		app.renderImportError(w, r, "file", "must be provided")
+		return
+	}
+	defer file.Close()
+	if header.Size > maxImportSize {
+		app.renderImportError(w, r, "file", "must be smaller than 10 MB")
+		return
+	}
+
+	opts := importer.Options{WeekStart: app.weekStart}
+	if user := app.authenticatedUser(r); user != nil {
+		opts.Location = user.Location()
+	}
+
+	result, err := importer.Parse(format, file, header.Size, opts)
+	if err != nil {
+		app.renderImportError(w, r, "file", "couldn't be read: "+err.Error())
+		return
+	}
+
+	err = app.imports.PlanImport(userID, result.Habits)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	id, err := app.pendingImports.put(userID, result.Habits)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	preview := &ImportPreview{
+		ID:       id,
+		Format:   result.Format,
+		Habits:   result.Habits,
+		Warnings: result.Warnings,
+	}
+	for _, h := range result.Habits {
+		if h.ExistingID == 0 {
+			preview.NewHabits++
+		}
+		preview.Entries += len(h.Entries)
+		preview.Conflicts += len(h.Conflicts)
+	}
+
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Import Preview"
+	templatePageData.IsAuthenticated = true
+	templatePageData.Import = preview
+
+	err = app.render(w, r, http.StatusOK, "import_preview.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// importCommit saves a previewed import in a single transaction
+func (app *application) importCommit(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	habits, ok := app.pendingImports.take(r.PostForm.Get("import_id"), userID)
+	if !ok {
+		app.session.Put(r, "flash", "That import has expired. Please upload the file again.")
+		http.Redirect(w, r, "/import", http.StatusSeeOther)
+		return
+	}
+
+	// Plan again in case habits or entries changed since the preview
+	err = app.imports.PlanImport(userID, habits)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	summary, err := app.imports.Import(userID, habits, r.PostForm.Get("on_conflict") == "overwrite")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	message := "Import complete: " + strconv.Itoa(summary.HabitsCreated) + " habits created, " +
+		strconv.Itoa(summary.EntriesImported) + " entries imported"
+	if summary.EntriesSkipped > 0 {
+		message += ", " + strconv.Itoa(summary.EntriesSkipped) + " conflicting entries kept as they were"
+	}
+	app.session.Put(r, "flash", message+".")
+	http.Redirect(w, r, "/apphome", http.StatusSeeOther)
+}
+
+// renderImportError shows the upload form again with an error
+func (app *application) renderImportError(w http.ResponseWriter, r *http.Request, field, message string) {
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Import - Error"
+	templatePageData.IsAuthenticated = true
+	templatePageData.ImportFormats = importer.PermittedFormats
+	templatePageData.FormErrors = map[string]string{field: message}
+	templatePageData.FormData = map[string]string{"format": r.PostForm.Get("format")}
+
+	err := app.render(w, r, http.StatusUnprocessableEntity, "import.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
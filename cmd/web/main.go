@@ -7,34 +7,60 @@ import (
 	"html/template"
 	"log/slog"
 	"os"
+	"sync/atomic"
 	"time"
+	_ "time/tzdata" // Embedded time zone database for users' time zones
 
 	_ "github.com/lib/pq"
 
 	"github.com/amari03/habit-tracker/internal/data"
-	"github.com/golangcollege/sessions"
+	"github.com/amari03/habit-tracker/internal/lifecycle"
+	"github.com/amari03/habit-tracker/internal/mailer"
+	"github.com/amari03/habit-tracker/internal/oidc"
+	"github.com/amari03/habit-tracker/internal/sessions"
 )
 
 type application struct {
-	logger        *slog.Logger
-	addr          *string
-	dsn           *string
-	habits        *data.HabitModel
-	templateCache map[string]*template.Template
-	session       *sessions.Session
-	users         *data.UserModel
+	logger          *slog.Logger
+	config          config
+	db              *sql.DB
+	metrics         *appMetrics
+	habits          *data.HabitModel
+	entries         *data.HabitEntryModel
+	stats           *data.StatsModel
+	weekStart       time.Weekday
+	backfillDays    int
+	templateCache   map[string]*template.Template
+	session         *sessions.Session
+	users           *data.UserModel
+	tokens          *data.APITokenModel
+	imports         *data.ImportModel
+	pendingImports  *pendingImports
+	userTokens      *data.TokenModel
+	recoveryCodes   *data.RecoveryCodeModel
+	identities      *data.UserIdentityModel
+	oidc            *oidc.Provider // nil when single sign-on is off
+	ssoName         string         // what the single sign-on button calls the provider
+	mailer          mailer.Mailer
+	baseURL         string
+	workers         *lifecycle.Manager
+	limiter         *rateLimiter // every request, per IP; nil when disabled
+	emailLimiter    *rateLimiter // forms that send email, per IP
+	loginsByIP      *loginThrottle
+	loginsByAccount *loginThrottle
+	shuttingDown    atomic.Bool // set once a signal asks the server to stop
 }
 
 func main() {
-	addr := flag.String("addr", "", "HTTP network address")
-	dsn := flag.String("dsn", "", "PostgreSQL DSN")
-	secret := flag.String("secret", "2h78MaIuawl77Ta+iMohobAyXBRfW6RitGQhD5qx0Ps", "Secret key for session")
-
-	flag.Parse()
-
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
-	db, err := openDB(*dsn)
+	cfg, err := loadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		logger.Error("Invalid configuration", "error", err)
+		os.Exit(2)
+	}
+
+	db, err := openDB(cfg)
 	if err != nil {
 		logger.Error("Unable to connect to DB", "error", err)
 		os.Exit(1)
@@ -42,25 +68,90 @@ func main() {
 	defer db.Close()
 	logger.Info("Database connection established")
 
+	// Subcommands run instead of the server
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "migrate" {
+			logger.Error("Unknown command", "command", args[0])
+			os.Exit(2)
+		}
+		err = runMigrate(db, args[1:], os.Stdout)
+		if err != nil {
+			logger.Error("Migration failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	err = checkSchema(db, cfg.autoMigrate, logger)
+	if err != nil {
+		logger.Error("Database schema check failed", "error", err)
+		os.Exit(1)
+	}
+
 	templateCache, err := newTemplateCache()
 	if err != nil {
 		logger.Error("Template caching failed", "error", err)
 		os.Exit(1)
 	}
 
-	session := sessions.New([]byte(*secret))
-	session.Lifetime = 12 * time.Hour
+	session := sessions.New(db)
+	session.Lifetime = cfg.session.lifetime
+	session.IdleTimeout = cfg.session.idleTimeout
+	session.QueryTimeout = cfg.db.queryTimeout
 	session.Secure = true
+	session.UserIDKey = "authenticatedUserID"
+
+	var mail mailer.Mailer = &mailer.LogMailer{Logger: logger, Dir: cfg.mailDir, Sender: cfg.smtp.sender}
+	if cfg.smtp.host != "" {
+		mail, err = mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
+		if err != nil {
+			logger.Error("Invalid SMTP settings", "error", err)
+			os.Exit(2)
+		}
+	}
+
+	timeout := cfg.db.queryTimeout
 
 	app := &application{
-		logger:        logger,
-		addr:          addr,
-		dsn:           dsn,
-		habits:        &data.HabitModel{DB: db}, // Initialize with DB
-		templateCache: templateCache,
-		session:       session,
-		users:         &data.UserModel{DB: db}, // Initialize with DB
+		logger:          logger,
+		config:          cfg,
+		db:              db,
+		metrics:         newAppMetrics(db),
+		habits:          &data.HabitModel{DB: db, Timeout: timeout}, // Initialize with DB
+		entries:         &data.HabitEntryModel{DB: db, Timeout: timeout},
+		stats:           &data.StatsModel{DB: db, Timeout: timeout},
+		weekStart:       cfg.weekStart,
+		backfillDays:    cfg.backfillDays,
+		templateCache:   templateCache,
+		session:         session,
+		users:           &data.UserModel{DB: db, Timeout: timeout}, // Initialize with DB
+		tokens:          &data.APITokenModel{DB: db, Timeout: timeout},
+		imports:         &data.ImportModel{DB: db, Timeout: timeout},
+		pendingImports:  newPendingImports(),
+		userTokens:      &data.TokenModel{DB: db, Timeout: timeout},
+		recoveryCodes:   &data.RecoveryCodeModel{DB: db, Timeout: timeout},
+		identities:      &data.UserIdentityModel{DB: db, Timeout: timeout},
+		mailer:          mail,
+		workers:         lifecycle.New(logger),
+		baseURL:         cfg.baseURL,
+		emailLimiter:    newRateLimiter(1.0/60, 5), // 5 emails, then one a minute
+		loginsByIP:      newLoginThrottle(20),      // allow for several people behind one NAT
+		loginsByAccount: newLoginThrottle(5),
+	}
+	if cfg.limiter.enabled {
+		app.limiter = newRateLimiter(cfg.limiter.rps, cfg.limiter.burst)
 	}
+	if cfg.oidc.issuer != "" {
+		app.oidc = oidc.New(cfg.oidc.issuer, cfg.oidc.clientID, cfg.oidc.clientSecret, app.baseURL+"/user/login/oidc/callback")
+		app.ssoName = cfg.oidc.name
+	}
+	session.ErrorHandler = app.serverError
+	session.ClientIP = app.clientIP
+
+	// Clear out expired and idle sessions
+	app.workers.Every("session cleanup", time.Hour, func(context.Context) error {
+		return session.DeleteExpired()
+	})
 
 	err = app.serve()
 	if err != nil {
@@ -69,11 +160,14 @@ func main() {
 	}
 }
 
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", dsn)
+func openDB(cfg config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
 		return nil, err
 	}
+	db.SetMaxOpenConns(cfg.db.maxOpenConns)
+	db.SetMaxIdleConns(cfg.db.maxIdleConns)
+	db.SetConnMaxIdleTime(cfg.db.maxIdleTime)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/metrics"
+)
+
+const routeContextKey = contextKey("route")
+
+// appMetrics are the metrics served on /metrics.
+type appMetrics struct {
+	registry        *metrics.Registry
+	requests        *metrics.Counter
+	requestDuration *metrics.Histogram
+	entriesLogged   *metrics.Counter
+	signups         *metrics.Counter
+	logins          *metrics.Counter
+}
+
+// newAppMetrics registers the app's metrics, including the stats of the
+// database connection pool.
+func newAppMetrics(db *sql.DB) *appMetrics {
+	r := metrics.NewRegistry()
+	m := &appMetrics{
+		registry:        r,
+		requests:        r.NewCounter("http_requests_total", "HTTP requests by route and status code.", "route", "status"),
+		requestDuration: r.NewHistogram("http_request_duration_seconds", "HTTP request latency by route.", metrics.DefaultBuckets, "route"),
+		entriesLogged:   r.NewCounter("habit_entries_logged_total", "Habit entries logged from the web app or the API, by status.", "status"),
+		signups:         r.NewCounter("user_signups_total", "Accounts created, by method (password or sso).", "method"),
+		logins:          r.NewCounter("user_logins_total", "Login attempts by result (success or failure).", "result"),
+	}
+
+	stat := func(fn func(sql.DBStats) float64) func() float64 {
+		return func() float64 { return fn(db.Stats()) }
+	}
+	r.NewGaugeFunc("db_max_open_connections", "Maximum open database connections allowed.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	r.NewGaugeFunc("db_open_connections", "Open database connections, in use or idle.",
+		stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	r.NewGaugeFunc("db_in_use_connections", "Database connections in use.",
+		stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	r.NewGaugeFunc("db_idle_connections", "Idle database connections.",
+		stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	r.NewCounterFunc("db_wait_count_total", "Times a query waited for a free database connection.",
+		stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	r.NewCounterFunc("db_wait_duration_seconds_total", "Time spent waiting for a free database connection.",
+		stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	r.NewCounterFunc("db_max_idle_time_closed_total", "Database connections closed for being idle too long.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+
+	return m
+}
+
+// measure counts each request and how long it took, by the route pattern
+// that handled it. Requests rejected before routing, like rate limited ones,
+// and requests for no route are counted as "other", which keeps arbitrary
+// URLs out of the labels.
+func (app *application) measure(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := new(string)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		r = r.WithContext(context.WithValue(r.Context(), routeContextKey, route))
+		next.ServeHTTP(rec, r)
+
+		// r.Pattern is set by the mux this middleware wraps; routes further in
+		// record theirs with recordRoute
+		if *route == "" && r.Pattern != "/" {
+			*route = r.Pattern
+		}
+		if *route == "" {
+			*route = "other"
+		}
+		app.metrics.requests.Inc(*route, strconv.Itoa(rec.status))
+		app.metrics.requestDuration.Observe(time.Since(start).Seconds(), *route)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// recordRoute passes the pattern the mux matched back out to measure, as the
+// middleware in between hands the mux copies of the request.
+func (app *application) recordRoute(mux *http.ServeMux) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r)
+		if route, ok := r.Context().Value(routeContextKey).(*string); ok {
+			*route = r.Pattern
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+// statusRecorder remembers the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.status = code
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// healthz tells the load balancer the process is up. It doesn't check the
+// database, so an outage there doesn't get every instance restarted.
+func (app *application) healthz(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "ok"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readyz tells the load balancer whether to send this instance traffic,
+// which it can only serve while the database answers and until it starts
+// shutting down.
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	status, body := http.StatusOK, envelope{"status": "ready"}
+	if app.shuttingDown.Load() {
+		status, body = http.StatusServiceUnavailable, envelope{"status": "shutting down"}
+	} else if err := app.db.PingContext(ctx); err != nil {
+		app.logger.Error("readiness check failed", "error", err)
+		status, body = http.StatusServiceUnavailable, envelope{"status": "unavailable", "error": "database unreachable"}
+	}
+
+	err := app.writeJSON(w, status, body, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,14 +1,31 @@
 package main
 
 import (
-	"github.com/justinas/nosurf"
+	"context"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+	"github.com/amari03/habit-tracker/internal/validator"
+	"github.com/justinas/nosurf"
+)
+
+type contextKey string
+
+const (
+	authenticatedUserContextKey = contextKey("authenticatedUser")
+	apiTokenContextKey          = contextKey("apiToken")
 )
 
+// tokenTouchInterval limits how often an API token's last used time is updated
+const tokenTouchInterval = time.Minute
+
 func (app *application) loggingMiddleware(next http.Handler) http.Handler {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var (
-			ip     = r.RemoteAddr
+			ip     = app.clientIP(r)
 			proto  = r.Proto
 			method = r.Method
 			uri    = r.URL.RequestURI()
@@ -22,6 +39,137 @@ func (app *application) loggingMiddleware(next http.Handler) http.Handler {
 
 }
 
+// authenticate loads the logged-in user (if any) and stores it in the request
+// context, so handlers can resolve dates in the user's time zone. A session
+// that refers to a user who no longer exists, or that was started before the
+// user's sessions were invalidated (e.g. by a password reset), is logged out.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		// Stylesheets and scripts don't depend on who's asking, so they don't
+		// need a database query each
+		if strings.HasPrefix(r.URL.Path, "/static/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Already authenticated with an API token
+		if app.authenticatedUser(r) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := app.authenticatedUserID(r)
+		if id == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := app.users.Get(id)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.session.Remove(r, "authenticatedUserID")
+				next.ServeHTTP(w, r)
+				return
+			}
+			app.serverError(w, r, err)
+			return
+		}
+
+		if version, _ := app.session.Get(r, "sessionVersion").(int); version != user.SessionVersion {
+			app.session.Remove(r, "authenticatedUserID")
+			app.session.Remove(r, "sessionVersion")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), authenticatedUserContextKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// authenticateToken authenticates requests that carry an
+// "Authorization: Bearer <token>" header using a personal access token
+// instead of the session cookie. The session is ignored for these requests, so
+// a missing, invalid or expired token is rejected rather than falling back to
+// the cookie. Tokens only work for the JSON API, and read tokens only for GET
+// requests.
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		tokenPlaintext, ok := bearerToken(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			app.errorResponse(w, r, http.StatusUnauthorized, "API tokens can only be used with the /api/v1 endpoints")
+			return
+		}
+
+		v := validator.NewValidator()
+		data.ValidateTokenPlaintext(v, tokenPlaintext)
+		if !v.ValidData() {
+			app.invalidTokenResponse(w, r)
+			return
+		}
+
+		token, err := app.tokens.GetForPlaintext(tokenPlaintext)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.invalidTokenResponse(w, r)
+			} else {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !token.Allows(r.Method) {
+			app.errorResponse(w, r, http.StatusForbidden, "this token only has read access")
+			return
+		}
+
+		user, err := app.users.Get(token.UserID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				app.invalidTokenResponse(w, r)
+			} else {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if !user.Active {
+			app.invalidTokenResponse(w, r)
+			return
+		}
+
+		// Don't write to the database on every request of a busy script
+		if time.Since(token.LastUsedAt) > tokenTouchInterval {
+			err = app.tokens.Touch(token.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), authenticatedUserContextKey, user)
+		ctx = context.WithValue(ctx, apiTokenContextKey, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// bearerToken returns the token from an "Authorization: Bearer <token>" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
 // requireAuthentication is a middleware that ensures a user is logged in.
 // If not, it redirects them to the login page.
 func (app *application) requireAuthentication(next http.Handler) http.Handler {
@@ -54,6 +202,13 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 func (app *application) noSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next) // This is the key change back
 
+	// Token-authenticated requests never use the session cookie, so a forged
+	// cross-site request can't ride on them and they don't need a CSRF token.
+	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		_, ok := bearerToken(r)
+		return ok
+	})
+
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
 		Path:     "/",
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/migrate"
+	"github.com/amari03/habit-tracker/migrations"
+)
+
+const migrateUsage = `usage: web [flags] migrate up [N]    apply all pending migrations, or the next N
+       web [flags] migrate down [N]  roll back the last migration, or the last N ("all" for every one)
+       web [flags] migrate status    list the migrations and which are applied`
+
+// runMigrate runs the migrate subcommand.
+func runMigrate(db *sql.DB, args []string, out io.Writer) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New(migrateUsage)
+	}
+
+	migrator, err := migrate.New(db, migrations.Files)
+	if err != nil {
+		return err
+	}
+
+	// Migrations can take a while on a big database, so there's no timeout
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		n, err := migrationCount(args, 0)
+		if err != nil {
+			return err
+		}
+		applied, err := migrator.Up(ctx, n)
+		for _, m := range applied {
+			fmt.Fprintf(out, "applied %d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(out, "no pending migrations")
+		}
+		return err
+
+	case "down":
+		n := 0
+		if len(args) < 2 || args[1] != "all" {
+			n, err = migrationCount(args, 1)
+			if err != nil {
+				return err
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, n)
+		for _, m := range rolledBack {
+			fmt.Fprintf(out, "rolled back %d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(rolledBack) == 0 {
+			fmt.Fprintln(out, "no migrations to roll back")
+		}
+		return err
+
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS")
+		for _, m := range migrator.Migrations {
+			state := "applied"
+			switch {
+			case m.Version > status.Current:
+				state = "pending"
+			case m.Version == status.Current && status.Dirty:
+				state = "dirty"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", m.Version, m.Name, state)
+		}
+		if status.Unknown {
+			fmt.Fprintf(tw, "%d\t(unknown to this build)\tapplied\n", status.Current)
+		}
+		return tw.Flush()
+	}
+
+	return errors.New(migrateUsage)
+}
+
+// migrationCount parses the optional N argument, defaulting to def.
+func migrationCount(args []string, def int) (int, error) {
+	if len(args) < 2 {
+		return def, nil
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("N must be a positive whole number\n%s", migrateUsage)
+	}
+	return n, nil
+}
+
+// checkSchema makes sure the database schema is up to date before the app
+// starts, applying the pending migrations if autoMigrate is set. A schema
+// newer than this build is only logged, so an older build can be rolled back
+// to after a deploy.
+func checkSchema(db *sql.DB, autoMigrate bool, logger *slog.Logger) error {
+	migrator, err := migrate.New(db, migrations.Files)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status.Dirty:
+		return migrate.ErrDirty
+	case status.Current > status.Latest:
+		logger.Warn("Database schema is newer than this build", "version", status.Current, "latest_known", status.Latest)
+		return nil
+	case len(status.Pending) == 0:
+		return nil
+	case !autoMigrate:
+		return fmt.Errorf("database schema is at version %d but this build needs %d; run `web migrate up` or start with -auto-migrate",
+			status.Current, status.Latest)
+	}
+
+	applied, err := migrator.Up(context.Background(), 0)
+	for _, m := range applied {
+		logger.Info("Applied migration", "version", m.Version, "name", m.Name)
+	}
+	return err
+}
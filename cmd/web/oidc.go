@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/amari03/habit-tracker/internal/data"
+	"github.com/amari03/habit-tracker/internal/oidc"
+	"github.com/amari03/habit-tracker/internal/validator"
+)
+
+// oidcLogin sends the user to the identity provider to log in. The values
+// that tie the provider's response to this attempt wait in the session.
+func (app *application) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	req, err := oidc.NewAuthRequest()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	authURL, err := app.oidc.AuthCodeURL(r.Context(), req)
+	if err != nil {
+		app.logger.Error("starting single sign-on", "error", err)
+		app.session.Put(r, "flash", app.ssoName+" login isn't available right now. Please try again later.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	app.session.Put(r, "oidcState", req.State)
+	app.session.Put(r, "oidcNonce", req.Nonce)
+	app.session.Put(r, "oidcVerifier", req.Verifier)
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// oidcCallback is where the identity provider sends the user back to. It
+// logs in the user linked to their account at the provider, linking or
+// creating one by the account's verified email address the first time.
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	// Each attempt can only be completed once
+	req := &oidc.AuthRequest{
+		State:    app.session.GetString(r, "oidcState"),
+		Nonce:    app.session.GetString(r, "oidcNonce"),
+		Verifier: app.session.GetString(r, "oidcVerifier"),
+	}
+	app.session.Remove(r, "oidcState")
+	app.session.Remove(r, "oidcNonce")
+	app.session.Remove(r, "oidcVerifier")
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	if req.State == "" || subtle.ConstantTimeCompare([]byte(state), []byte(req.State)) != 1 {
+		app.oidcFailed(w, r, "Your "+app.ssoName+" login expired or was started in another browser. Please try again.")
+		return
+	}
+	if providerErr := query.Get("error"); providerErr != "" {
+		app.logger.Info("single sign-on refused", "error", providerErr, "description", query.Get("error_description"))
+		app.oidcFailed(w, r, app.ssoName+" login was cancelled or refused.")
+		return
+	}
+
+	claims, err := app.oidc.Exchange(r.Context(), query.Get("code"), req)
+	if err != nil {
+		app.logger.Error("completing single sign-on", "error", err)
+		app.oidcFailed(w, r, app.ssoName+" login failed. Please try again.")
+		return
+	}
+
+	user, err := app.userForIdentity(claims)
+	if err != nil {
+		if errors.Is(err, errUnverifiedEmail) {
+			app.oidcFailed(w, r, "Your "+app.ssoName+" account doesn't have a verified email address, so it can't be used to log in here.")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if user.TwoFactorEnabled() {
+		app.startTwoFactorLogin(r, user)
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	app.logIn(r, user)
+	app.session.Put(r, "flash", "You have been logged in successfully!")
+	http.Redirect(w, r, "/apphome", http.StatusSeeOther)
+}
+
+var errUnverifiedEmail = errors.New("identity provider account has no verified email")
+
+// userForIdentity finds the user linked to the provider's account. The first
+// time, it links the user with the same verified email address, or creates
+// one. Either way the account is active, as the provider vouches for the email.
+//
+// An account that was never activated may have been signed up by someone
+// else to pre-hijack the address, so its password isn't trusted: it's
+// replaced with a random one before the account is linked.
+func (app *application) userForIdentity(claims *oidc.Claims) (*data.User, error) {
+	userID, err := app.identities.GetUserID(app.oidc.Issuer, claims.Subject)
+	switch {
+	case err == nil:
+		return app.users.Get(userID)
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return nil, err
+	}
+
+	email := strings.TrimSpace(claims.Email)
+	v := validator.NewValidator()
+	v.Check(bool(claims.EmailVerified), "email", "must be verified")
+	v.Check(validator.MaxLength(email, 255), "email", "must not be more than 255 characters")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	if !v.ValidData() {
+		return nil, errUnverifiedEmail
+	}
+
+	user, err := app.users.GetByEmail(email)
+	switch {
+	case err == nil:
+		if !user.Active {
+			err = app.takeOverUnverifiedUser(user)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case errors.Is(err, data.ErrRecordNotFound):
+		user, err = app.createSSOUser(email, claims.Name)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	err = app.identities.Link(app.oidc.Issuer, claims.Subject, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// createSSOUser signs up a user who logged in with the identity provider. They
+// get a random password, so they can only log in with a password after
+// resetting it.
+func (app *application) createSSOUser(email, name string) (*data.User, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	if runes := []rune(name); len(runes) > 255 {
+		name = string(runes[:255])
+	}
+
+	user := &data.User{
+		Name:     name,
+		Email:    email,
+		Active:   true,
+		Timezone: "UTC",
+	}
+	err := setRandomPassword(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.users.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+	app.logger.Info("created user from single sign-on", "user_id", user.ID)
+	app.metrics.signups.Inc("sso")
+
+	// Read it back for the defaults the database filled in
+	return app.users.Get(user.ID)
+}
+
+// takeOverUnverifiedUser activates an account whose email the provider has
+// verified, dropping everything set up before the email was proven: the
+// password, any sessions, a pending email change and two-factor settings.
+func (app *application) takeOverUnverifiedUser(user *data.User) error {
+	err := setRandomPassword(user)
+	if err != nil {
+		return err
+	}
+	user.Active = true
+	user.SessionVersion++
+	user.PendingEmail = ""
+	user.TOTPSecret = ""
+
+	err = app.users.Update(user)
+	if err != nil {
+		return err
+	}
+	err = app.recoveryCodes.DeleteAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+	app.logger.Info("activated unverified user from single sign-on", "user_id", user.ID)
+	return nil
+}
+
+// setRandomPassword gives the user a password nobody knows, so they can only
+// log in with a password, or confirm changes with one, after setting it from
+// a reset link.
+func setRandomPassword(user *data.User) error {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+	user.HasPassword = false
+	return user.Password.Set(base64.RawURLEncoding.EncodeToString(randomBytes))
+}
+
+// oidcFailed sends the user back to the login page with a message
+func (app *application) oidcFailed(w http.ResponseWriter, r *http.Request, message string) {
+	app.session.Put(r, "flash", message)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+	"github.com/amari03/habit-tracker/internal/validator"
+)
+
+// passwordResetTokenTTL is how long a password reset link stays valid
+const passwordResetTokenTTL = 45 * time.Minute
+
+// forgotPasswordForm asks for the email address to send a reset link to
+func (app *application) forgotPasswordForm(w http.ResponseWriter, r *http.Request) {
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Forgot Password"
+	templatePageData.Flash = app.session.PopString(r, "flash")
+
+	err := app.render(w, r, http.StatusOK, "forgot_password.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// forgotPassword emails a password reset link. Like resendActivation, the
+// response doesn't reveal whether the address has an account.
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	email := r.PostForm.Get("email")
+
+	v := validator.NewValidator()
+	v.Check(validator.NotBlank(email), "email", "must be provided")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	if !v.ValidData() {
+		templatePageData := NewTemplateData()
+		templatePageData.Title = "Forgot Password - Error"
+		templatePageData.FormErrors = v.Errors
+		templatePageData.FormData = map[string]string{"email": email}
+		err := app.render(w, r, http.StatusUnprocessableEntity, "forgot_password.tmpl", templatePageData)
+		if err != nil {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.users.GetByEmail(email)
+	switch {
+	case err == nil:
+		err = app.sendPasswordResetEmail(user)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "If "+email+" belongs to an account, a link to reset its password is on its way. It expires in 45 minutes.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// sendPasswordResetEmail emails the user a link to set a new password
+func (app *application) sendPasswordResetEmail(user *data.User) error {
+	token, err := app.userTokens.New(user.ID, passwordResetTokenTTL, data.ScopePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	emailData := map[string]any{
+		"Name":     user.Name,
+		"ResetURL": app.baseURL + "/user/password/reset?token=" + url.QueryEscape(token.Plaintext),
+	}
+	app.background(func() {
+		err := app.mailer.Send(user.Email, "password_reset.tmpl", emailData)
+		if err != nil {
+			app.logger.Error("sending password reset email", "user_id", user.ID, "error", err)
+		}
+	})
+	return nil
+}
+
+// sendSetPasswordLink emails a user who signed up with single sign-on a link
+// to set a password, which they need to confirm changes to their account.
+// Following it proves they own the address, as their session alone doesn't.
+func (app *application) sendSetPasswordLink(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err := app.sendPasswordResetEmail(user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "A link to set your password is on its way to "+user.Email+". It expires in 45 minutes.")
+	http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+}
+
+// resetPasswordForm shows the new password form for the token in the link
+func (app *application) resetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Reset Password"
+	templatePageData.FormData = map[string]string{"token": r.URL.Query().Get("token")}
+
+	err := app.render(w, r, http.StatusOK, "reset_password.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// resetPassword sets the new password, uses up the token and logs out every
+// existing session of the user
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	tokenPlaintext := r.PostForm.Get("token")
+	passwordInput := r.PostForm.Get("password")
+
+	v := validator.NewValidator()
+	data.ValidateTokenPlaintext(v, tokenPlaintext)
+	if v.ValidData() {
+		data.ValidatePasswordPlaintext(v, passwordInput)
+		v.Check(passwordInput == r.PostForm.Get("confirm_password"), "confirm_password", "Passwords don't match")
+	}
+
+	// The token is used up before the password is set, so two requests with
+	// the same link can't both reset it
+	var user *data.User
+	if v.ValidData() {
+		var userID int64
+		userID, err = app.userTokens.Consume(data.ScopePasswordReset, tokenPlaintext)
+		if err == nil {
+			user, err = app.users.Get(userID)
+		}
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.serverError(w, r, err)
+				return
+			}
+			v.AddError("token", "invalid")
+		}
+	}
+
+	if !v.ValidData() {
+		templatePageData := NewTemplateData()
+		templatePageData.Title = "Reset Password - Error"
+		templatePageData.FormErrors = v.Errors
+		templatePageData.FormData = map[string]string{"token": tokenPlaintext}
+		err := app.render(w, r, http.StatusUnprocessableEntity, "reset_password.tmpl", templatePageData)
+		if err != nil {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(passwordInput)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	user.HasPassword = true
+	// The reset link proves the user owns the address, so it activates the account too
+	user.Active = true
+	user.SessionVersion++
+
+	err = app.users.Update(user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Any other reset links sent before this one
+	err = app.userTokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Whoever knew the old password may have created API tokens with it
+	err = app.tokens.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Remove(r, "authenticatedUserID")
+	app.session.Remove(r, "sessionVersion")
+	app.session.Put(r, "flash", "Your password has been reset, you've been logged out everywhere and your API tokens have been revoked. Please log in with your new password.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
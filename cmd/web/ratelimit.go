@@ -0,0 +1,280 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket per client: each client can make burst
+// requests at once, refilled at rps requests per second.
+type rateLimiter struct {
+	mu        sync.Mutex
+	rps       float64
+	burst     float64
+	clients   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rps:     rps,
+		burst:   float64(burst),
+		clients: make(map[string]*bucket),
+	}
+}
+
+// allow takes a token from key's bucket. When the bucket is empty it reports
+// how long until the next token.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	// Forget clients whose buckets have long since refilled
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, b := range l.clients {
+			if now.Sub(b.lastSeen) > 3*time.Minute {
+				delete(l.clients, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.clients[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, lastSeen: now}
+		l.clients[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.lastSeen).Seconds()*l.rps)
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rps * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimit limits how fast each client IP can make requests to next.
+// Clients over the limit get 429 Too Many Requests with a Retry-After header.
+func (app *application) rateLimit(limiter *rateLimiter, next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ok, retryAfter := limiter.allow(app.clientIP(r))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				app.errorResponse(w, r, http.StatusTooManyRequests, "rate limit exceeded")
+			} else {
+				app.clientError(w, http.StatusTooManyRequests)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// clientIP returns the IP address the request came from, looking through the
+// -trusted-proxies.
+func (app *application) clientIP(r *http.Request) string {
+	return clientIP(r, app.config.trustedProxies)
+}
+
+// clientIP returns the IP address the request came from. When it came through
+// one of the trusted proxies the address is taken from X-Forwarded-For instead,
+// reading from the right past any more trusted proxies, since everything to the
+// left of the last one the client could have made up.
+func clientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !isTrustedProxy(addr, trustedProxies) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// Not an address a proxy would have added, so stop at the last
+			// one that was
+			break
+		}
+		addr = hop.Unmap()
+		if !isTrustedProxy(addr, trustedProxies) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+func isTrustedProxy(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// loginThrottle slows down password guessing by counting failed logins per
+// key (an IP address or an account). After a few free attempts each further
+// failure locks the key out for twice as long as the last, up to a maximum.
+// Each attempt is counted as a failure before the password is checked, and
+// locked out logins are refused before then, so guessing can't tie up the CPU
+// with bcrypt either.
+type loginThrottle struct {
+	mu        sync.Mutex
+	free      int           // failures allowed before the lockouts start
+	base      time.Duration // first lockout
+	max       time.Duration // longest lockout
+	reset     time.Duration // failures are forgotten after this long without another
+	failures  map[string]*loginFailures
+	lastSweep time.Time
+}
+
+type loginFailures struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+func newLoginThrottle(free int) *loginThrottle {
+	return &loginThrottle{
+		free:     free,
+		base:     30 * time.Second,
+		max:      15 * time.Minute,
+		reset:    time.Hour,
+		failures: make(map[string]*loginFailures),
+	}
+}
+
+// wait returns how long until key may try to log in again, or zero if it
+// isn't locked out.
+func (t *loginThrottle) wait(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.failures[key]
+	if !ok {
+		return 0
+	}
+	return max(time.Until(f.lockedUntil), 0)
+}
+
+// loginAttempt is a login counted against a key while its password or code
+// is checked, until release says it didn't fail after all.
+type loginAttempt struct {
+	key             string
+	lockedUntil     time.Time // the lockout this attempt started, if any
+	prevLockedUntil time.Time
+}
+
+// attempt counts a login for key as failed before the password is checked,
+// so concurrent requests can't all get past the lockout check before any of
+// their failures is recorded. If key is locked out nothing is counted and
+// wait is how long until it may try again. Otherwise attemptsLeft is how many
+// attempts are left before the lockouts start (zero or less once they have).
+func (t *loginThrottle) attempt(key string) (a loginAttempt, attemptsLeft int, wait time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.lastSweep) > time.Minute {
+		for k, f := range t.failures {
+			if now.Sub(f.lastFailure) > t.reset && now.After(f.lockedUntil) {
+				delete(t.failures, k)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	f, ok := t.failures[key]
+	if ok && now.Before(f.lockedUntil) {
+		return loginAttempt{}, 0, f.lockedUntil.Sub(now)
+	}
+	if !ok || now.Sub(f.lastFailure) > t.reset {
+		f = &loginFailures{}
+		t.failures[key] = f
+	}
+
+	a = loginAttempt{key: key, prevLockedUntil: f.lockedUntil}
+	f.count++
+	f.lastFailure = now
+
+	if over := f.count - t.free; over > 0 {
+		lockout := t.max
+		if over <= 16 { // the cap applies long before this; larger shifts could overflow
+			lockout = min(t.base<<(over-1), t.max)
+		}
+		f.lockedUntil = now.Add(lockout)
+		a.lockedUntil = f.lockedUntil
+	}
+	return a, t.free - f.count, 0
+}
+
+// release uncounts an attempt that didn't fail, e.g. because the password
+// was right, lifting the lockout it started unless a later attempt has
+// started another.
+func (t *loginThrottle) release(a loginAttempt) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.failures[a.key]
+	if !ok {
+		return
+	}
+	f.count = max(f.count-1, 0)
+	if !a.lockedUntil.IsZero() && f.lockedUntil.Equal(a.lockedUntil) {
+		f.lockedUntil = a.prevLockedUntil
+	}
+}
+
+// succeed forgets the failures recorded against key.
+func (t *loginThrottle) succeed(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.failures, key)
+}
+
+// humanDuration rounds d up to whole minutes, or seconds under a minute,
+// for messages like "try again in 2 minutes".
+func humanDuration(d time.Duration) string {
+	if d < time.Minute {
+		seconds := int(math.Ceil(d.Seconds()))
+		if seconds == 1 {
+			return "1 second"
+		}
+		return strconv.Itoa(seconds) + " seconds"
+	}
+	minutes := int(math.Ceil(d.Minutes()))
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return strconv.Itoa(minutes) + " minutes"
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("fd00::/8"),
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		trusted      []netip.Prefix
+		want         string
+	}{
+		{"no proxies", "203.0.113.7:5123", nil, nil, "203.0.113.7"},
+		{"header ignored without trusted proxies", "203.0.113.7:5123", []string{"198.51.100.1"}, nil, "203.0.113.7"},
+		{"header ignored from an untrusted address", "203.0.113.7:5123", []string{"198.51.100.1"}, trusted, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:5123", []string{"198.51.100.1"}, trusted, "198.51.100.1"},
+		{"client spoofs a hop", "10.0.0.2:5123", []string{"1.2.3.4, 198.51.100.1"}, trusted, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:5123", []string{"198.51.100.1, 10.0.0.9", "10.1.0.1"}, trusted, "198.51.100.1"},
+		{"only trusted hops", "10.0.0.2:5123", []string{"10.0.0.9"}, trusted, "10.0.0.9"},
+		{"trusted proxy without the header", "10.0.0.2:5123", nil, trusted, "10.0.0.2"},
+		{"junk hop", "10.0.0.2:5123", []string{"198.51.100.1, junk"}, trusted, "10.0.0.2"},
+		{"ipv6 proxy", "[fd00::1]:5123", []string{"2001:db8::7"}, trusted, "2001:db8::7"},
+		{"ipv4-mapped hop", "10.0.0.2:5123", []string{"::ffff:198.51.100.1"}, trusted, "198.51.100.1"},
+		{"no port", "203.0.113.7", nil, trusted, "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+
+			if got := clientIP(r, tt.trusted); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePrefixes(t *testing.T) {
+	got, err := parsePrefixes(" 10.0.0.0/8, 192.168.1.7 ,fd00::/8,,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.7/32"),
+		netip.MustParsePrefix("fd00::/8"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parsePrefixes = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("parsePrefixes[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	for _, s := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0.1:80"} {
+		if _, err := parsePrefixes(s); err == nil {
+			t.Errorf("parsePrefixes(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+// expire ends key's current lockout, as if it had been waited out.
+func (t *loginThrottle) expire(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.failures[key].lockedUntil = time.Now()
+}
+
+// near reports whether d is within a second of want, allowing for the time
+// the test takes.
+func near(d, want time.Duration) bool {
+	return d > want-time.Second && d <= want
+}
+
+func TestLoginThrottleBackoff(t *testing.T) {
+	throttle := newLoginThrottle(3)
+
+	for want := 2; want >= 0; want-- {
+		a, left, wait := throttle.attempt("key")
+		if wait != 0 || left != want || !a.lockedUntil.IsZero() {
+			t.Fatalf("free attempt: left = %d, wait = %v, locked = %v; want left = %d and no lockout", left, wait, !a.lockedUntil.IsZero(), want)
+		}
+	}
+
+	// Each failure past the free ones locks the key out for twice as long,
+	// up to the maximum
+	for _, want := range []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 15 * time.Minute, 15 * time.Minute} {
+		a, _, wait := throttle.attempt("key")
+		if wait != 0 {
+			t.Fatalf("attempt refused after the lockout ended, wait = %v", wait)
+		}
+		if got := time.Until(a.lockedUntil); !near(got, want) {
+			t.Errorf("lockout = %v, want %v", got, want)
+		}
+
+		_, _, wait = throttle.attempt("key")
+		if !near(wait, want) {
+			t.Errorf("attempt while locked out: wait = %v, want %v", wait, want)
+		}
+		if got := throttle.wait("key"); !near(got, want) {
+			t.Errorf("wait = %v, want %v", got, want)
+		}
+		throttle.expire("key")
+	}
+
+	if wait := throttle.wait("other"); wait != 0 {
+		t.Errorf("another key: wait = %v, want 0", wait)
+	}
+}
+
+func TestLoginThrottleRelease(t *testing.T) {
+	throttle := newLoginThrottle(1)
+
+	// Released attempts don't count
+	for range 3 {
+		a, left, _ := throttle.attempt("key")
+		if left != 0 {
+			t.Fatalf("left = %d, want 0", left)
+		}
+		throttle.release(a)
+	}
+
+	// Releasing the attempt that started a lockout lifts it
+	throttle.attempt("key")
+	a, _, _ := throttle.attempt("key")
+	if a.lockedUntil.IsZero() {
+		t.Fatal("the second failure didn't start a lockout")
+	}
+	throttle.release(a)
+	if wait := throttle.wait("key"); wait != 0 {
+		t.Errorf("after release: wait = %v, want 0", wait)
+	}
+
+	// The next failure is the second again, so the lockout doesn't grow
+	a, _, _ = throttle.attempt("key")
+	if got := time.Until(a.lockedUntil); !near(got, 30*time.Second) {
+		t.Errorf("lockout after release = %v, want 30s", got)
+	}
+
+	// A lockout started by a later attempt stays when an earlier one is
+	// released
+	throttle.expire("key")
+	earlier, _, _ := throttle.attempt("key")
+	throttle.expire("key")
+	throttle.attempt("key")
+	throttle.release(earlier)
+	if wait := throttle.wait("key"); wait == 0 {
+		t.Error("releasing an earlier attempt lifted a later lockout")
+	}
+}
+
+func TestLoginThrottleSucceed(t *testing.T) {
+	throttle := newLoginThrottle(2)
+
+	throttle.attempt("key")
+	throttle.attempt("key")
+	throttle.attempt("key")
+	if throttle.wait("key") == 0 {
+		t.Fatal("not locked out after three failures")
+	}
+
+	throttle.succeed("key")
+	if wait := throttle.wait("key"); wait != 0 {
+		t.Errorf("after success: wait = %v, want 0", wait)
+	}
+	if _, left, _ := throttle.attempt("key"); left != 1 {
+		t.Errorf("after success: left = %d, want 1", left)
+	}
+}
+
+func TestLoginThrottleConcurrentAttempts(t *testing.T) {
+	throttle := newLoginThrottle(3)
+
+	// Only the free attempts and the one that starts the lockout get to
+	// check a password, however many arrive at once
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, wait := throttle.attempt("key"); wait == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != 4 {
+		t.Errorf("%d attempts allowed, want 4", got)
+	}
+}
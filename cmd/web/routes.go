@@ -12,9 +12,22 @@ func (app *application) routes() http.Handler {
 	// Public routes
 	mux.HandleFunc("GET /{$}", app.landingPageHandler)
 	mux.HandleFunc("GET /user/signup", app.signupUserForm)
-	mux.HandleFunc("POST /user/signup", app.signupUser)
+	mux.Handle("POST /user/signup", app.rateLimit(app.emailLimiter, http.HandlerFunc(app.signupUser)))
 	mux.HandleFunc("GET /user/login", app.loginUserForm)
 	mux.HandleFunc("POST /user/login", app.loginUser)
+	mux.HandleFunc("GET /user/login/2fa", app.twoFactorLoginForm)
+	mux.HandleFunc("POST /user/login/2fa", app.twoFactorLogin)
+	mux.HandleFunc("GET /user/login/oidc", app.oidcLogin)
+	mux.HandleFunc("GET /user/login/oidc/callback", app.oidcCallback)
+	mux.HandleFunc("GET /user/activate", app.activateUserForm)
+	mux.HandleFunc("POST /user/activate", app.activateUser)
+	mux.Handle("POST /user/activate/resend", app.rateLimit(app.emailLimiter, http.HandlerFunc(app.resendActivation)))
+	mux.HandleFunc("GET /user/password/forgot", app.forgotPasswordForm)
+	mux.Handle("POST /user/password/forgot", app.rateLimit(app.emailLimiter, http.HandlerFunc(app.forgotPassword)))
+	mux.HandleFunc("GET /user/password/reset", app.resetPasswordForm)
+	mux.HandleFunc("POST /user/password/reset", app.resetPassword)
+	mux.HandleFunc("GET /user/settings/email/confirm", app.confirmEmailForm)
+	mux.HandleFunc("POST /user/settings/email/confirm", app.confirmEmail)
 
 	// Authenticated routes
 	mux.Handle("GET /apphome", app.requireAuthentication(http.HandlerFunc(app.homeHandler)))
@@ -22,14 +35,17 @@ func (app *application) routes() http.Handler {
 	// Habit form pages (formerly habitsHandler)
 	mux.Handle("GET /daily", app.requireAuthentication(http.HandlerFunc(app.showHabitFormPageHandler)))
 	mux.Handle("GET /weekly", app.requireAuthentication(http.HandlerFunc(app.showHabitFormPageHandler)))
+	mux.Handle("GET /custom", app.requireAuthentication(http.HandlerFunc(app.showHabitFormPageHandler)))
 
 	// Habit entries pages (new)
 	mux.Handle("GET /daily/entries", app.requireAuthentication(http.HandlerFunc(app.showHabitEntriesPageHandler)))
 	mux.Handle("GET /weekly/entries", app.requireAuthentication(http.HandlerFunc(app.showHabitEntriesPageHandler)))
+	mux.Handle("GET /custom/entries", app.requireAuthentication(http.HandlerFunc(app.showHabitEntriesPageHandler)))
 
 	// Progress routes (called by entries pages)
 	mux.Handle("GET /daily/progress", app.requireAuthentication(http.HandlerFunc(app.progressHandler)))
 	mux.Handle("GET /weekly/progress", app.requireAuthentication(http.HandlerFunc(app.progressHandler)))
+	mux.Handle("GET /custom/progress", app.requireAuthentication(http.HandlerFunc(app.progressHandler)))
 
 	// Create habit
 	mux.Handle("POST /habits/create", app.requireAuthentication(http.HandlerFunc(app.createHabitHandler)))
@@ -46,8 +62,81 @@ func (app *application) routes() http.Handler {
 	// Log entry for habit completion
 	mux.Handle("POST /habits/entries/{id}", app.requireAuthentication(http.HandlerFunc(app.logEntryHandler)))
 
+	// Undo today's (or this week's) entry for a habit
+	mux.Handle("POST /habits/entries/{id}/clear", app.requireAuthentication(http.HandlerFunc(app.clearEntryHandler)))
+
+	// Habit detail page with heatmap, completion rate and streaks
+	mux.Handle("GET /habits/{id}", app.requireAuthentication(http.HandlerFunc(app.showHabitHandler)))
+
+	// Entry history: backfill, edit and delete past entries
+	mux.Handle("GET /habits/{id}/history", app.requireAuthentication(http.HandlerFunc(app.habitHistoryHandler)))
+	mux.Handle("POST /habits/{id}/history/{entryID}/update", app.requireAuthentication(http.HandlerFunc(app.updateEntryHandler)))
+	mux.Handle("POST /habits/{id}/history/{entryID}/delete", app.requireAuthentication(http.HandlerFunc(app.deleteEntryHandler)))
+
+	// Name, email, password and two-factor authentication
+	mux.Handle("GET /user/settings", app.requireAuthentication(http.HandlerFunc(app.userSettingsForm)))
+	mux.Handle("POST /user/settings/name", app.requireAuthentication(http.HandlerFunc(app.updateName)))
+	mux.Handle("POST /user/settings/email", app.requireAuthentication(http.HandlerFunc(app.changeEmail)))
+	mux.Handle("POST /user/settings/password", app.requireAuthentication(http.HandlerFunc(app.changePassword)))
+	mux.Handle("POST /user/settings/password/link", app.requireAuthentication(app.rateLimit(app.emailLimiter, http.HandlerFunc(app.sendSetPasswordLink))))
+	mux.Handle("GET /user/settings/2fa", app.requireAuthentication(http.HandlerFunc(app.twoFactorSetupForm)))
+	mux.Handle("POST /user/settings/2fa", app.requireAuthentication(http.HandlerFunc(app.enableTwoFactor)))
+	mux.Handle("POST /user/settings/2fa/recovery", app.requireAuthentication(http.HandlerFunc(app.regenerateRecoveryCodes)))
+	mux.Handle("POST /user/settings/2fa/disable", app.requireAuthentication(http.HandlerFunc(app.disableTwoFactor)))
+	mux.Handle("GET /user/delete", app.requireAuthentication(http.HandlerFunc(app.deleteAccountForm)))
+	mux.Handle("POST /user/delete", app.requireAuthentication(http.HandlerFunc(app.deleteAccount)))
+
+	// Sessions on other devices
+	mux.Handle("GET /user/sessions", app.requireAuthentication(http.HandlerFunc(app.devicesPage)))
+	mux.Handle("POST /user/sessions/{id}/delete", app.requireAuthentication(http.HandlerFunc(app.revokeDevice)))
+	mux.Handle("POST /user/sessions/others/delete", app.requireAuthentication(http.HandlerFunc(app.revokeOtherDevices)))
+
+	// Time zone and day boundary
+	mux.Handle("GET /user/preferences", app.requireAuthentication(http.HandlerFunc(app.userPreferencesForm)))
+	mux.Handle("POST /user/preferences", app.requireAuthentication(http.HandlerFunc(app.userPreferences)))
+
+	// Export habits and entries as CSV or JSON
+	mux.Handle("GET /export", app.requireAuthentication(http.HandlerFunc(app.exportPage)))
+	mux.Handle("GET /export/{format}", app.requireAuthentication(http.HandlerFunc(app.exportHandler)))
+
+	// Import history from other habit trackers
+	mux.Handle("GET /import", app.requireAuthentication(http.HandlerFunc(app.importPage)))
+	mux.Handle("POST /import", app.requireAuthentication(http.HandlerFunc(app.importPreview)))
+	mux.Handle("POST /import/commit", app.requireAuthentication(http.HandlerFunc(app.importCommit)))
+
+	// Personal access tokens for the JSON API
+	mux.Handle("GET /user/tokens", app.requireAuthentication(http.HandlerFunc(app.apiTokensPage)))
+	mux.Handle("POST /user/tokens", app.requireAuthentication(http.HandlerFunc(app.createAPIToken)))
+	mux.Handle("POST /user/tokens/{id}/delete", app.requireAuthentication(http.HandlerFunc(app.revokeAPIToken)))
+
+	// JSON API
+	mux.Handle("GET /api/v1/habits", app.requireAPIAuthentication(http.HandlerFunc(app.apiListHabitsHandler)))
+	mux.Handle("POST /api/v1/habits", app.requireAPIAuthentication(http.HandlerFunc(app.apiCreateHabitHandler)))
+	mux.Handle("GET /api/v1/habits/{id}", app.requireAPIAuthentication(http.HandlerFunc(app.apiShowHabitHandler)))
+	mux.Handle("PATCH /api/v1/habits/{id}", app.requireAPIAuthentication(http.HandlerFunc(app.apiUpdateHabitHandler)))
+	mux.Handle("DELETE /api/v1/habits/{id}", app.requireAPIAuthentication(http.HandlerFunc(app.apiDeleteHabitHandler)))
+	mux.Handle("GET /api/v1/habits/{id}/entries", app.requireAPIAuthentication(http.HandlerFunc(app.apiListEntriesHandler)))
+	mux.Handle("POST /api/v1/habits/{id}/entries", app.requireAPIAuthentication(http.HandlerFunc(app.apiLogEntryHandler)))
+	mux.Handle("GET /api/v1/export/{format}", app.requireAPIAuthentication(http.HandlerFunc(app.apiExportHandler)))
+	mux.Handle("GET /api/v1/progress", app.requireAPIAuthentication(http.HandlerFunc(app.apiProgressHandler)))
+
 	// Logout
 	mux.Handle("GET /user/logout", app.requireAuthentication(http.HandlerFunc(app.logoutUserHandler)))
 
-	return app.session.Enable(app.noSurf(app.loggingMiddleware(mux)))
+	// Probes skip sessions, CSRF and rate limiting, as the load balancer calls
+	// them often from a few addresses
+	root := http.NewServeMux()
+	root.HandleFunc("GET /healthz", app.healthz)
+	root.HandleFunc("GET /readyz", app.readyz)
+	root.Handle("/", app.rateLimit(app.limiter, app.session.Enable(app.limitImportSize(app.noSurf(app.authenticateToken(app.authenticate(app.loggingMiddleware(app.recordRoute(mux)))))))))
+
+	return app.measure(root)
+}
+
+// metricsRoutes are served on the metrics listener, which only the scraper
+// should be able to reach.
+func (app *application) metricsRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", app.metrics.registry.Handler())
+	return mux
 }
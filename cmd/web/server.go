@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -15,14 +21,97 @@ func (app *application) serve() error {
 	}
 
 	srv := &http.Server{
-		Addr:         *app.addr,
+		Addr:         app.config.addr,
 		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  app.config.http.idleTimeout,
+		ReadTimeout:  app.config.http.readTimeout,
+		WriteTimeout: app.config.http.writeTimeout,
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 		TLSConfig:    tlsConfig,
 	}
+
+	// On SIGINT or SIGTERM, fail the readiness check, then stop taking
+	// requests and let the ones in flight and the background workers finish,
+	// for up to the shutdown timeout
+	shutdownError := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+		signal.Stop(quit) // a second signal kills the process as usual
+
+		app.logger.Info("shutting down server", "signal", s.String())
+		app.shuttingDown.Store(true)
+		if delay := app.config.http.shutdownDelay; delay > 0 {
+			// Keep serving until the load balancer sees /readyz fail and
+			// stops sending requests here
+			time.Sleep(delay)
+		}
+
+		// Requests get up to two thirds of the timeout and the workers the
+		// rest, so slow requests can't use up the time the workers need to
+		// finish what they're doing
+		deadline := time.Now().Add(app.config.http.shutdownTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.http.shutdownTimeout*2/3)
+		err := srv.Shutdown(ctx)
+		cancel()
+
+		app.logger.Info("completing background tasks")
+		ctx, cancel = context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		shutdownError <- errors.Join(err, app.workers.Shutdown(ctx))
+	}()
+
+	err := app.serveMetrics()
+	if err != nil {
+		return err
+	}
+
 	app.logger.Info("starting server", "addr", srv.Addr)
-	return srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
+	err = srv.ListenAndServeTLS(app.config.tls.certFile, app.config.tls.keyFile)
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+	app.logger.Info("stopped server", "addr", srv.Addr)
+	return nil
+}
+
+// serveMetrics serves /metrics on its own listener, away from the public one,
+// until the background workers are shut down. It listens before returning, so
+// an address that's taken stops the app starting.
+func (app *application) serveMetrics() error {
+	if app.config.metricsAddr == "" {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", app.config.metricsAddr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:      app.metricsRoutes(),
+		IdleTimeout:  app.config.http.idleTimeout,
+		ReadTimeout:  app.config.http.readTimeout,
+		WriteTimeout: app.config.http.writeTimeout,
+		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	app.workers.Go("metrics server", func(ctx context.Context) error {
+		go func() {
+			<-ctx.Done()
+			srv.Close()
+		}()
+		err := srv.Serve(ln)
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	})
+	app.logger.Info("serving metrics", "addr", ln.Addr().String())
+	return nil
 }
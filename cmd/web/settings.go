@@ -0,0 +1,408 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+	"github.com/amari03/habit-tracker/internal/validator"
+)
+
+// emailChangeTokenTTL is how long the link confirming a new email address stays valid
+const emailChangeTokenTTL = 24 * time.Hour
+
+// userSettingsForm shows the forms for changing the user's name, email and password
+func (app *application) userSettingsForm(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	templatePageData := NewTemplateData()
+	templatePageData.Flash = app.session.PopString(r, "flash")
+	app.renderSettings(w, r, http.StatusOK, user, templatePageData)
+}
+
+// renderSettings fills in the user's current details, keeping anything the
+// user typed into a form that failed validation
+func (app *application) renderSettings(w http.ResponseWriter, r *http.Request, status int, user *data.User, templatePageData *TemplateData) {
+	templatePageData.Title = "Account Settings"
+	if status != http.StatusOK {
+		templatePageData.Title = "Account Settings - Error"
+	}
+	templatePageData.IsAuthenticated = true
+	templatePageData.NoPassword = !user.HasPassword
+	for field, value := range map[string]string{
+		"name":          user.Name,
+		"email":         user.Email,
+		"pending_email": user.PendingEmail,
+	} {
+		if _, ok := templatePageData.FormData[field]; !ok {
+			templatePageData.FormData[field] = value
+		}
+	}
+
+	templatePageData.TwoFactor = &TwoFactor{Enabled: user.TwoFactorEnabled()}
+	if user.TwoFactorEnabled() {
+		var err error
+		templatePageData.TwoFactor.RecoveryCodesLeft, err = app.recoveryCodes.Remaining(user.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	err := app.render(w, r, status, "settings.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// updateName saves the user's display name
+func (app *application) updateName(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.PostForm.Get("name"))
+
+	v := validator.NewValidator()
+	v.Check(validator.NotBlank(name), "name", "must be provided")
+	v.Check(validator.MaxLength(name, 255), "name", "must not be more than 255 characters")
+	if !v.ValidData() {
+		templatePageData := NewTemplateData()
+		templatePageData.FormErrors = v.Errors
+		templatePageData.FormData = map[string]string{"name": name}
+		app.renderSettings(w, r, http.StatusUnprocessableEntity, user, templatePageData)
+		return
+	}
+
+	user.Name = name
+	err = app.users.Update(user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Name updated.")
+	http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+}
+
+// changeEmail starts an email change: the new address is only used once the
+// link sent to it has been followed, so a typo can't lock the user out.
+func (app *application) changeEmail(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(r.PostForm.Get("email"))
+
+	v := validator.NewValidator()
+	v.Check(validator.NotBlank(email), "email", "must be provided")
+	v.Check(validator.MaxLength(email, 255), "email", "must not be more than 255 characters")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	v.Check(!strings.EqualFold(email, user.Email), "email", "is already your email address")
+	err = checkCurrentPassword(v, user, r.PostForm.Get("email_password"), "email_password")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if v.ValidData() {
+		_, err = app.users.GetByEmail(email)
+		switch {
+		case err == nil:
+			v.AddError("email", "Email address is already registered")
+		case !errors.Is(err, data.ErrRecordNotFound):
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !v.ValidData() {
+		templatePageData := NewTemplateData()
+		templatePageData.FormErrors = v.Errors
+		templatePageData.FormData = map[string]string{"email": email}
+		app.renderSettings(w, r, http.StatusUnprocessableEntity, user, templatePageData)
+		return
+	}
+
+	// Only the latest requested address can be confirmed
+	err = app.userTokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	user.PendingEmail = email
+	err = app.users.Update(user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	token, err := app.userTokens.New(user.ID, emailChangeTokenTTL, data.ScopeEmailChange)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	emailData := map[string]any{
+		"Name":       user.Name,
+		"Email":      email,
+		"ConfirmURL": app.baseURL + "/user/settings/email/confirm?token=" + url.QueryEscape(token.Plaintext),
+	}
+	app.background(func() {
+		err := app.mailer.Send(email, "email_change.tmpl", emailData)
+		if err != nil {
+			app.logger.Error("sending email change confirmation", "user_id", user.ID, "error", err)
+		}
+	})
+
+	app.session.Put(r, "flash", "We've sent a link to "+email+". Your email address will change once you follow it.")
+	http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+}
+
+// confirmEmailForm shows the button that confirms a new email address. Like
+// activation, the emailed link only opens this page.
+func (app *application) confirmEmailForm(w http.ResponseWriter, r *http.Request) {
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Confirm Email"
+	templatePageData.FormData = map[string]string{"token": r.URL.Query().Get("token")}
+
+	err := app.render(w, r, http.StatusOK, "confirm_email.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// confirmEmail switches the account over to its pending email address
+func (app *application) confirmEmail(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	tokenPlaintext := r.PostForm.Get("token")
+
+	v := validator.NewValidator()
+	data.ValidateTokenPlaintext(v, tokenPlaintext)
+	if !v.ValidData() {
+		app.renderConfirmEmailError(w, r, "This confirmation link is invalid, has expired or has already been used.")
+		return
+	}
+
+	user, err := app.userTokens.GetUserForToken(data.ScopeEmailChange, tokenPlaintext)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.renderConfirmEmailError(w, r, "This confirmation link is invalid, has expired or has already been used.")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	if user.PendingEmail == "" {
+		app.renderConfirmEmailError(w, r, "This confirmation link is invalid, has expired or has already been used.")
+		return
+	}
+
+	user.Email = user.PendingEmail
+	user.PendingEmail = ""
+	err = app.users.Update(user)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicateEmail) {
+			app.renderConfirmEmailError(w, r, "That email address has been registered by another account since you asked to change to it.")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.userTokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your email address is now "+user.Email+".")
+	if app.authenticatedUserID(r) == user.ID {
+		http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// renderConfirmEmailError explains why the new email address couldn't be confirmed
+func (app *application) renderConfirmEmailError(w http.ResponseWriter, r *http.Request, message string) {
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Confirm Email - Error"
+	templatePageData.FormErrors = map[string]string{"generic": message}
+
+	err := app.render(w, r, http.StatusUnprocessableEntity, "confirm_email.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// changePassword sets a new password after checking the current one. Other
+// sessions are logged out; this one stays logged in.
+func (app *application) changePassword(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	passwordInput := r.PostForm.Get("password")
+
+	v := validator.NewValidator()
+	err = checkCurrentPassword(v, user, r.PostForm.Get("current_password"), "current_password")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data.ValidatePasswordPlaintext(v, passwordInput)
+	v.Check(passwordInput == r.PostForm.Get("confirm_password"), "confirm_password", "Passwords don't match")
+
+	if !v.ValidData() {
+		templatePageData := NewTemplateData()
+		templatePageData.FormErrors = v.Errors
+		app.renderSettings(w, r, http.StatusUnprocessableEntity, user, templatePageData)
+		return
+	}
+
+	err = user.Password.Set(passwordInput)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	user.SessionVersion++
+
+	err = app.users.Update(user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.tokens.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "sessionVersion", user.SessionVersion)
+	app.session.Put(r, "flash", "Password changed. You've been logged out on your other devices and your API tokens have been revoked.")
+	http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+}
+
+// checkCurrentPassword adds an error to field unless password is the user's
+// current password. Users who signed up with single sign-on have to set a
+// password first.
+func checkCurrentPassword(v *validator.Validator, user *data.User, password, field string) error {
+	if !user.HasPassword {
+		v.AddError(field, "Set a password first, from the link we can email you on the settings page")
+		return nil
+	}
+	if !validator.NotBlank(password) {
+		v.AddError(field, "Current password must be provided")
+		return nil
+	}
+	ok, err := user.Password.Matches(password)
+	if err != nil {
+		return err
+	}
+	v.Check(ok, field, "Current password is incorrect")
+	return nil
+}
+
+// deleteAccountForm asks for the password before deleting the account, with
+// a last chance to download an export
+func (app *application) deleteAccountForm(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Delete Account"
+	templatePageData.IsAuthenticated = true
+	templatePageData.NoPassword = !user.HasPassword
+
+	err := app.render(w, r, http.StatusOK, "delete_account.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// deleteAccount removes the user with all their habits and entries and logs them out
+func (app *application) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	v := validator.NewValidator()
+	err = checkCurrentPassword(v, user, r.PostForm.Get("password"), "password")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !v.ValidData() {
+		templatePageData := NewTemplateData()
+		templatePageData.Title = "Delete Account - Error"
+		templatePageData.IsAuthenticated = true
+		templatePageData.NoPassword = !user.HasPassword
+		templatePageData.FormErrors = v.Errors
+		err := app.render(w, r, http.StatusUnprocessableEntity, "delete_account.tmpl", templatePageData)
+		if err != nil {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.users.Delete(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Destroy(r)
+	app.session.Put(r, "flash", "Your account and all of its habits and entries have been deleted.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"github.com/amari03/habit-tracker/internal/data"
+	"html/template"
+	"strings"
 	"time"
 )
 
@@ -12,14 +14,86 @@ type TemplateData struct {
 	PermittedFrequencies []string
 	FormErrors           map[string]string
 	FormData             map[string]string
-	Habits               []*data.Habit // Changed from DailyHabits/WeeklyHabits to generic Habits
-	Habit                *data.Habit   // Single habit (for edit/view)
-	Progress             int           // For progress bar
-	Frequency            string        // "daily" or "weekly"
-	Flash                string        // For flash messages
-	IsAuthenticated      bool          // For authentication check
-	CSRFToken            string        // CSRF token for forms
-	UserName             string        // <<< ADD THIS FIELD for the user's name
+	Habits               []*data.Habit     // Changed from DailyHabits/WeeklyHabits to generic Habits
+	Habit                *data.Habit       // Single habit (for edit/view)
+	Progress             int               // For progress bar
+	Frequency            string            // "daily", "weekly" or "custom"
+	Flash                string            // For flash messages
+	IsAuthenticated      bool              // For authentication check
+	CSRFToken            string            // CSRF token for forms
+	UserName             string            // <<< ADD THIS FIELD for the user's name
+	WeekdayOptions       []WeekdayOption   // Checkboxes for the custom schedule picker
+	PeriodLabel          string            // e.g. "Week of Mon 12 Oct 2026" on the weekly entries page
+	Entries              []data.HabitEntry // A habit's entry history
+	PermittedStatuses    []string          // Statuses that can be chosen when editing an entry
+	MinEntryDate         string            // Earliest date entries can be backfilled for (YYYY-MM-DD)
+	MaxEntryDate         string            // Latest date entries can be logged for (YYYY-MM-DD)
+	Timezones            []string          // Suggested time zones for the preferences form
+	CompletionRate       int               // Percentage of a habit's entries that are completed
+	Heatmap              *Heatmap          // Year heatmap for the habit detail page
+	Stats                *Dashboard        // Statistics shown on the home page
+	APITokens            []data.APIToken   // The user's personal access tokens
+	NewAPIToken          *data.APIToken    // A token that has just been created, shown once
+	PermittedScopes      []string          // Scopes that can be chosen for a new token
+	ImportFormats        []string          // Formats that can be chosen on the import form
+	Import               *ImportPreview    // What an uploaded import would change
+	Devices              []Device          // The user's sessions
+	TwoFactor            *TwoFactor        // The user's two-factor authentication
+	SSOName              string            // Identity provider offered on the login page, empty when single sign-on is off
+	NoPassword           bool              // The user signed up with single sign-on and hasn't set a password yet
+}
+
+// TwoFactor describes the user's two-factor authentication on the settings pages.
+type TwoFactor struct {
+	Enabled           bool
+	RecoveryCodesLeft int
+	URI               template.URL // otpauth:// URI of a secret being set up, built by us so its scheme is trusted
+	Secret            string       // the same secret, grouped for typing in by hand
+	RecoveryCodes     []string     // newly generated codes, shown once
+}
+
+// ImportPreview describes an uploaded import before it is saved.
+type ImportPreview struct {
+	ID        string // identifies the pending import when it is confirmed
+	Format    string
+	Habits    []data.ImportedHabit
+	Warnings  []string
+	NewHabits int
+	Entries   int
+	Conflicts int // entries on dates that already have one
+}
+
+// Dashboard holds the statistics shown on the home page.
+type Dashboard struct {
+	CompletionRates []data.HabitCompletion
+	WeekdayScores   []data.WeekdayScore
+	BestWeekday     data.WeekdayScore
+	WorstWeekday    data.WeekdayScore
+	HasWeekdays     bool // false until a habit has been due on a past day
+	DailyScores     []data.DailyScore
+	Today           data.DailyScore
+}
+
+// WeekdayOption is a single day in the custom schedule picker.
+type WeekdayOption struct {
+	Value   string
+	Label   string
+	Checked bool
+}
+
+// weekdayOptions lists the days of the week starting from Monday, marking the selected ones.
+func weekdayOptions(selected data.Weekdays) []WeekdayOption {
+	options := make([]WeekdayOption, 0, 7)
+	for i := 1; i <= 7; i++ {
+		day := time.Weekday(i % 7)
+		label := day.String()[:3]
+		options = append(options, WeekdayOption{
+			Value:   strings.ToLower(label),
+			Label:   label,
+			Checked: selected.Has(day),
+		})
+	}
+	return options
 }
 
 func NewTemplateData() *TemplateData {
@@ -30,5 +104,29 @@ func NewTemplateData() *TemplateData {
 		IsAuthenticated: false,             // Default to false
 		CSRFToken:       "",                // Default to empty string
 		UserName:        "",                // Initialize UserName
+		WeekdayOptions:  weekdayOptions(0),
+		Timezones:       commonTimezones,
 	}
 }
+
+// commonTimezones are suggested in the preferences form; any IANA time zone is accepted.
+var commonTimezones = []string{
+	"UTC",
+	"America/Belize",
+	"America/Chicago",
+	"America/Denver",
+	"America/Los_Angeles",
+	"America/Mexico_City",
+	"America/New_York",
+	"America/Sao_Paulo",
+	"Europe/London",
+	"Europe/Paris",
+	"Europe/Berlin",
+	"Africa/Lagos",
+	"Africa/Johannesburg",
+	"Asia/Kolkata",
+	"Asia/Shanghai",
+	"Asia/Tokyo",
+	"Australia/Sydney",
+	"Pacific/Auckland",
+}
@@ -20,7 +20,9 @@ func newTemplateCache() (map[string]*template.Template, error) {
 		var parseErr error
 
 		// For pages that are standalone (like login.tmpl and signup.tmpl), parse them directly.
-		if name == "login.tmpl" || name == "signup.tmpl" || name == "landing.tmpl" { // standalone pages
+		if name == "login.tmpl" || name == "signup.tmpl" || name == "landing.tmpl" ||
+			name == "activate.tmpl" || name == "forgot_password.tmpl" || name == "reset_password.tmpl" ||
+			name == "confirm_email.tmpl" || name == "login_2fa.tmpl" { // standalone pages
 			ts, parseErr = template.ParseFiles(page)
 		} else {
 			// Assume other pages use base.tmpl
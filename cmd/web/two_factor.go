@@ -0,0 +1,405 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+	"github.com/amari03/habit-tracker/internal/totp"
+	"github.com/amari03/habit-tracker/internal/validator"
+)
+
+// twoFactorLoginTTL is how long after the password step the code can be entered
+const twoFactorLoginTTL = 5 * time.Minute
+
+// totpIssuer labels the account in authenticator apps
+const totpIssuer = "Habit Tracker"
+
+// startTwoFactorLogin remembers that the user got their password right. They
+// aren't logged in until the second step passes.
+func (app *application) startTwoFactorLogin(r *http.Request, user *data.User) {
+	app.session.RenewToken(r)
+	app.session.Put(r, "twoFactorUserID", user.ID)
+	app.session.Put(r, "twoFactorStarted", time.Now().Unix())
+}
+
+// twoFactorLoginUserID returns the ID of the user half way through logging in,
+// or 0 if there isn't one or they took too long.
+func (app *application) twoFactorLoginUserID(r *http.Request) int64 {
+	id, _ := app.session.Get(r, "twoFactorUserID").(int64)
+	started, _ := app.session.Get(r, "twoFactorStarted").(int64)
+	if id != 0 && time.Since(time.Unix(started, 0)) < twoFactorLoginTTL {
+		return id
+	}
+	app.clearTwoFactorLogin(r)
+	return 0
+}
+
+func (app *application) clearTwoFactorLogin(r *http.Request) {
+	app.session.Remove(r, "twoFactorUserID")
+	app.session.Remove(r, "twoFactorStarted")
+}
+
+// twoFactorLoginForm asks for the code from the authenticator app
+func (app *application) twoFactorLoginForm(w http.ResponseWriter, r *http.Request) {
+	if app.twoFactorLoginUserID(r) == 0 {
+		app.session.Put(r, "flash", "Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Two-Factor Authentication"
+	templatePageData.Flash = app.session.PopString(r, "flash")
+
+	err := app.render(w, r, http.StatusOK, "login_2fa.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// twoFactorLogin finishes logging in with a code from the authenticator app
+// or one of the recovery codes. Wrong codes count as failed logins.
+func (app *application) twoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.users.Get(app.twoFactorLoginUserID(r))
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.clearTwoFactorLogin(r)
+			app.session.Put(r, "flash", "Please log in again.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	v := validator.NewValidator()
+	code := strings.TrimSpace(r.PostForm.Get("code"))
+	if !validator.NotBlank(code) {
+		v.AddError("generic", "Enter the code from your authenticator app or a recovery code.")
+		app.renderTwoFactorLoginError(w, r, http.StatusUnprocessableEntity, v.Errors)
+		return
+	}
+
+	attempt, wait := app.startLoginAttempt(app.clientIP(r), strings.ToLower(user.Email))
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		v.AddError("throttled", "Too many failed login attempts. Please wait "+humanDuration(wait)+" before trying again.")
+		app.renderTwoFactorLoginError(w, r, http.StatusTooManyRequests, v.Errors)
+		return
+	}
+
+	ok, usedRecoveryCode := true, false
+	if user.TwoFactorEnabled() { // it may have been turned off on another device meanwhile
+		if isTOTPCode(code) {
+			ok, err = app.checkTOTPCode(user, user.TOTPSecret, code)
+		} else {
+			ok, err = app.recoveryCodes.Use(user.ID, code)
+			usedRecoveryCode = ok
+		}
+		if err != nil {
+			app.releaseLoginAttempt(attempt)
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	if !ok {
+		app.loginFailed(v, attempt, "Invalid code.")
+		app.renderTwoFactorLoginError(w, r, http.StatusUnprocessableEntity, v.Errors)
+		return
+	}
+	app.loginSucceeded(attempt)
+
+	app.clearTwoFactorLogin(r)
+	app.logIn(r, user)
+
+	if !usedRecoveryCode {
+		app.session.Put(r, "flash", "You have been logged in successfully!")
+		http.Redirect(w, r, "/apphome", http.StatusSeeOther)
+		return
+	}
+
+	remaining, err := app.recoveryCodes.Remaining(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	message := "You have been logged in with a recovery code. You have " + strconv.Itoa(remaining) + " left"
+	if remaining <= 2 {
+		message += "; generate new ones in Account Settings"
+	}
+	app.session.Put(r, "flash", message+".")
+	http.Redirect(w, r, "/apphome", http.StatusSeeOther)
+}
+
+// renderTwoFactorLoginError shows the code form again with an error
+func (app *application) renderTwoFactorLoginError(w http.ResponseWriter, r *http.Request, status int, formErrors map[string]string) {
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Two-Factor Authentication - Error"
+	templatePageData.FormErrors = formErrors
+
+	err := app.render(w, r, status, "login_2fa.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// isTOTPCode reports whether code looks like a code from an authenticator app
+// rather than a recovery code.
+func isTOTPCode(code string) bool {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totp.Digits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// checkTOTPCode verifies a code against secret and records it as used, so
+// the same code can't be used again.
+func (app *application) checkTOTPCode(user *data.User, secret, code string) (bool, error) {
+	step, ok := totp.Verify(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return app.users.UseTOTPStep(user.ID, step)
+}
+
+// twoFactorSetupForm shows the secret to add to an authenticator app. The
+// secret is kept in the session, not the account, until a code from the app
+// proves it was added.
+func (app *application) twoFactorSetupForm(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+	if user.TwoFactorEnabled() {
+		http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+		return
+	}
+	if !user.HasPassword {
+		app.session.Put(r, "flash", "Set a password first, from the link we can email you below. Turning on two-factor authentication needs it.")
+		http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+		return
+	}
+
+	// Reuse the secret on reload, in case it's already been scanned
+	secret := app.session.GetString(r, "pendingTOTPSecret")
+	if secret == "" {
+		var err error
+		secret, err = totp.GenerateSecret()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.session.Put(r, "pendingTOTPSecret", secret)
+	}
+
+	app.renderTwoFactorSetup(w, r, http.StatusOK, user, secret, NewTemplateData())
+}
+
+// renderTwoFactorSetup shows the setup page for secret
+func (app *application) renderTwoFactorSetup(w http.ResponseWriter, r *http.Request, status int, user *data.User, secret string, templatePageData *TemplateData) {
+	templatePageData.Title = "Set Up Two-Factor Authentication"
+	if status != http.StatusOK {
+		templatePageData.Title = "Set Up Two-Factor Authentication - Error"
+	}
+	templatePageData.IsAuthenticated = true
+	templatePageData.TwoFactor = &TwoFactor{
+		URI:    template.URL(totp.URI(totpIssuer, user.Email, secret)),
+		Secret: groupSecret(secret),
+	}
+
+	err := app.render(w, r, status, "two_factor_setup.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// enableTwoFactor turns two-factor authentication on once the user has
+// entered a code from their app, and shows their recovery codes
+func (app *application) enableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	secret := app.session.GetString(r, "pendingTOTPSecret")
+	if user.TwoFactorEnabled() || secret == "" {
+		http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+		return
+	}
+
+	v := validator.NewValidator()
+	err = checkCurrentPassword(v, user, r.PostForm.Get("password"), "password")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	code := strings.TrimSpace(r.PostForm.Get("code"))
+	v.Check(validator.NotBlank(code), "code", "must be provided")
+	if v.ValidData() {
+		ok, err := app.checkTOTPCode(user, secret, code)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		v.Check(ok, "code", "is incorrect. Check the time on your device is right and try the next code")
+	}
+
+	if !v.ValidData() {
+		templatePageData := NewTemplateData()
+		templatePageData.FormErrors = v.Errors
+		app.renderTwoFactorSetup(w, r, http.StatusUnprocessableEntity, user, secret, templatePageData)
+		return
+	}
+
+	user.TOTPSecret = secret
+	err = app.users.Update(user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.session.Remove(r, "pendingTOTPSecret")
+
+	app.renderRecoveryCodes(w, r, user, "Two-factor authentication is on.")
+}
+
+// regenerateRecoveryCodes replaces the user's recovery codes with a new set
+func (app *application) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !user.TwoFactorEnabled() {
+		http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+		return
+	}
+
+	v := validator.NewValidator()
+	err = checkCurrentPassword(v, user, r.PostForm.Get("recovery_password"), "recovery_password")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !v.ValidData() {
+		templatePageData := NewTemplateData()
+		templatePageData.FormErrors = v.Errors
+		app.renderSettings(w, r, http.StatusUnprocessableEntity, user, templatePageData)
+		return
+	}
+
+	app.renderRecoveryCodes(w, r, user, "New recovery codes generated. Your old ones no longer work.")
+}
+
+// renderRecoveryCodes generates a new set of recovery codes and shows them.
+// Only their hashes are stored, so this is the only time they can be seen.
+func (app *application) renderRecoveryCodes(w http.ResponseWriter, r *http.Request, user *data.User, message string) {
+	codes, err := app.recoveryCodes.Replace(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	templatePageData := NewTemplateData()
+	templatePageData.Title = "Recovery Codes"
+	templatePageData.IsAuthenticated = true
+	templatePageData.Flash = message
+	templatePageData.TwoFactor = &TwoFactor{
+		Enabled:           true,
+		RecoveryCodes:     codes,
+		RecoveryCodesLeft: len(codes),
+	}
+
+	err = app.render(w, r, http.StatusOK, "recovery_codes.tmpl", templatePageData)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// disableTwoFactor turns two-factor authentication off and deletes the
+// recovery codes
+func (app *application) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	v := validator.NewValidator()
+	err = checkCurrentPassword(v, user, r.PostForm.Get("disable_password"), "disable_password")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !v.ValidData() {
+		templatePageData := NewTemplateData()
+		templatePageData.FormErrors = v.Errors
+		app.renderSettings(w, r, http.StatusUnprocessableEntity, user, templatePageData)
+		return
+	}
+
+	user.TOTPSecret = ""
+	err = app.users.Update(user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.recoveryCodes.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Two-factor authentication is off.")
+	http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+}
+
+// groupSecret splits a secret into groups of four, which is easier to type
+// into an app by hand
+func groupSecret(secret string) string {
+	var groups []string
+	for len(secret) > 4 {
+		groups = append(groups, secret[:4])
+		secret = secret[4:]
+	}
+	return strings.Join(append(groups, secret), " ")
+}
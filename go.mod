@@ -3,10 +3,7 @@ module github.com/amari03/habit-tracker
 go 1.23.5
 
 require (
-	github.com/golangcollege/sessions v1.2.0
 	github.com/justinas/nosurf v1.1.1
 	github.com/lib/pq v1.10.9
 	golang.org/x/crypto v0.0.0-20200317142112-1b76d66859c6
 )
-
-require golang.org/x/sys v0.0.0-20190412213103-97732733099d // indirect
@@ -0,0 +1,253 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/validator"
+)
+
+// Token scopes. Read tokens can only make GET requests; write tokens can also
+// create, change and delete.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// PermittedScopes lists the scopes an API token can have.
+var PermittedScopes = []string{ScopeRead, ScopeWrite}
+
+// APIToken is a personal access token used by scripts and other clients that
+// can't use the session cookie. Only the hash is stored; Plaintext is filled
+// in when the token is created and is shown to the user once.
+type APIToken struct {
+	ID         int64     `json:"id"`
+	Plaintext  string    `json:"token,omitempty"`
+	Hash       []byte    `json:"-"`
+	UserID     int64     `json:"-"`
+	Name       string    `json:"name"`
+	Scope      string    `json:"scope"`
+	Expiry     time.Time `json:"expiry"`       // zero if the token never expires
+	LastUsedAt time.Time `json:"last_used_at"` // zero if the token has never been used
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Allows reports whether the token's scope permits the given HTTP method.
+func (t *APIToken) Allows(method string) bool {
+	if t.Scope == ScopeWrite {
+		return true
+	}
+	return method == "GET" || method == "HEAD"
+}
+
+// Expired reports whether the token has passed its expiry.
+func (t *APIToken) Expired() bool {
+	return !t.Expiry.IsZero() && !time.Now().Before(t.Expiry)
+}
+
+// generateAPIToken creates a token with a random 26 character plaintext.
+// A ttl of zero creates a token that never expires.
+func generateAPIToken(userID int64, name, scope string, ttl time.Duration) (*APIToken, error) {
+	token := &APIToken{
+		UserID: userID,
+		Name:   name,
+		Scope:  scope,
+	}
+	if ttl > 0 {
+		token.Expiry = time.Now().Add(ttl)
+	}
+
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	return token, nil
+}
+
+// ValidateAPIToken checks the name and scope chosen for a new token.
+func ValidateAPIToken(v *validator.Validator, t *APIToken) {
+	v.Check(validator.NotBlank(t.Name), "name", "must be provided")
+	v.Check(validator.MaxLength(t.Name, 100), "name", "must not be more than 100 characters")
+	v.Check(validator.PermittedValue(t.Scope, PermittedScopes...), "scope", "must be 'read' or 'write'")
+}
+
+// ValidateTokenPlaintext checks a token sent by a client before it is looked up.
+func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
+	v.Check(validator.NotBlank(tokenPlaintext), "token", "must be provided")
+	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+}
+
+type APITokenModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // for each query; DefaultTimeout if zero
+}
+
+// New generates a token for the user and stores its hash.
+func (m *APITokenModel) New(userID int64, name, scope string, ttl time.Duration) (*APIToken, error) {
+	token, err := generateAPIToken(userID, name, scope, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(token)
+	return token, err
+}
+
+// Insert stores a generated token
+func (m *APITokenModel) Insert(token *APIToken) error {
+	query := `
+        INSERT INTO api_tokens (hash, user_id, name, scope, expiry)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, created_at`
+
+	var expiry sql.NullTime
+	if !token.Expiry.IsZero() {
+		expiry = sql.NullTime{Time: token.Expiry, Valid: true}
+	}
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query,
+		token.Hash,
+		token.UserID,
+		token.Name,
+		token.Scope,
+		expiry,
+	).Scan(&token.ID, &token.CreatedAt)
+}
+
+// GetAllForUser returns the user's tokens, newest first
+func (m *APITokenModel) GetAllForUser(userID int64) ([]APIToken, error) {
+	query := `
+        SELECT id, name, scope, expiry, last_used_at, created_at
+        FROM api_tokens
+        WHERE user_id = $1
+        ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		token := APIToken{UserID: userID}
+		var expiry, lastUsedAt sql.NullTime
+		err := rows.Scan(
+			&token.ID,
+			&token.Name,
+			&token.Scope,
+			&expiry,
+			&lastUsedAt,
+			&token.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		token.Expiry = expiry.Time
+		token.LastUsedAt = lastUsedAt.Time
+		tokens = append(tokens, token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// GetForPlaintext looks up an unexpired token by its plaintext value.
+// It returns ErrRecordNotFound for unknown, revoked and expired tokens alike.
+func (m *APITokenModel) GetForPlaintext(tokenPlaintext string) (*APIToken, error) {
+	query := `
+        SELECT id, user_id, name, scope, expiry, last_used_at, created_at
+        FROM api_tokens
+        WHERE hash = $1 AND (expiry IS NULL OR expiry > NOW())`
+
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	token := APIToken{Hash: hash[:]}
+	var expiry, lastUsedAt sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&token.Scope,
+		&expiry,
+		&lastUsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	token.Expiry = expiry.Time
+	token.LastUsedAt = lastUsedAt.Time
+
+	return &token, nil
+}
+
+// Touch records that the token has just been used
+func (m *APITokenModel) Touch(id int64) error {
+	query := `UPDATE api_tokens SET last_used_at = NOW() WHERE id = $1`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// Delete revokes one of the user's tokens
+func (m *APITokenModel) Delete(id int64, userID int64) error {
+	query := `DELETE FROM api_tokens WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteAllForUser revokes all of the user's tokens, e.g. after their
+// password is reset
+func (m *APITokenModel) DeleteAllForUser(userID int64) error {
+	query := `DELETE FROM api_tokens WHERE user_id = $1`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
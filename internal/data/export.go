@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/validator"
+)
+
+// ExportFilter narrows down an export. Zero values mean "no filter".
+type ExportFilter struct {
+	UserID    int64
+	HabitID   int64
+	Frequency string
+	From      time.Time
+	To        time.Time
+}
+
+// ValidateExportFilter checks the optional filters of an export.
+func ValidateExportFilter(v *validator.Validator, f ExportFilter) {
+	if f.Frequency != "" {
+		v.Check(validator.PermittedValue(f.Frequency, PermittedFrequencies...), "frequency", "must be 'daily', 'weekly' or 'custom'")
+	}
+	if !f.From.IsZero() && !f.To.IsZero() {
+		v.Check(!f.To.Before(f.From), "to", "must not be before the start date")
+	}
+}
+
+// ExportTimeout is how long an export can take. Exports can be large, so it's
+// far longer than a normal query.
+const ExportTimeout = time.Minute
+
+// Export streams the user's habits and their entries, ordered by habit and
+// then by date, calling fn once per entry. Habits with no entries in the date
+// range are still passed to fn once, with a nil entry. Rows are read one at a
+// time rather than loaded into memory, and the habit pointer stays the same
+// while its entries are being passed, so callers can group by habit cheaply.
+// ctx is usually the request's, so the query stops if the download is
+// abandoned.
+func (m *HabitModel) Export(ctx context.Context, f ExportFilter, fn func(habit *Habit, entry *HabitEntry) error) error {
+	query := `
+        SELECT h.id, h.user_id, h.title, COALESCE(h.description, ''), h.frequency, COALESCE(h.goal, ''),
+            h.times_per_week, h.weekdays, h.target_value, h.unit, h.created_at, h.updated_at,
+            e.id, e.entry_date, e.status, e.value, COALESCE(e.notes, ''), e.created_at
+        FROM habits h
+        LEFT JOIN habit_entries e ON e.habit_id = h.id
+            AND ($4::date IS NULL OR e.entry_date >= $4::date)
+            AND ($5::date IS NULL OR e.entry_date <= $5::date)
+        WHERE h.user_id = $1
+            AND ($2 = 0 OR h.id = $2)
+            AND ($3 = '' OR h.frequency = $3)
+        ORDER BY h.id, e.entry_date`
+
+	ctx, cancel := context.WithTimeout(ctx, ExportTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, f.UserID, f.HabitID, f.Frequency, nullDate(f.From), nullDate(f.To))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var habit *Habit
+	for rows.Next() {
+		var h Habit
+		var entryID sql.NullInt64
+		var entryDate, entryCreatedAt sql.NullTime
+		var status sql.NullString
+		var value sql.NullFloat64
+		var notes string
+
+		err := rows.Scan(
+			&h.ID,
+			&h.UserID,
+			&h.Title,
+			&h.Description,
+			&h.Frequency,
+			&h.Goal,
+			&h.TimesPerWeek,
+			&h.Weekdays,
+			&h.TargetValue,
+			&h.Unit,
+			&h.CreatedAt,
+			&h.UpdatedAt,
+			&entryID,
+			&entryDate,
+			&status,
+			&value,
+			&notes,
+			&entryCreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		if habit == nil || habit.ID != h.ID {
+			habit = &h
+		}
+
+		var entry *HabitEntry
+		if entryID.Valid {
+			entry = &HabitEntry{
+				ID:        entryID.Int64,
+				HabitID:   h.ID,
+				EntryDate: entryDate.Time,
+				Status:    status.String,
+				Value:     value.Float64,
+				Notes:     notes,
+				CreatedAt: entryCreatedAt.Time,
+			}
+		}
+
+		err = fn(habit, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// nullDate passes a zero time as NULL and anything else as a YYYY-MM-DD date.
+func nullDate(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.Format("2006-01-02"), Valid: true}
+}
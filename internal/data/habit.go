@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strconv"
 	"time"
 
 	"github.com/amari03/habit-tracker/internal/validator"
@@ -16,44 +17,121 @@ var (
 	// ErrForbiddenAccess = errors.New("forbidden access")
 )
 
+// DefaultTimeout is how long a query can take when a model's Timeout isn't set
+const DefaultTimeout = 3 * time.Second
+
+// queryContext returns the context for a query, cancelled after timeout.
+func queryContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
 type Habit struct {
-	ID          int64     `json:"id"`
-	UserID      int64     `json:"user_id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Frequency   string    `json:"frequency"` // daily, weekly
-	Goal        string    `json:"goal"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	TodayStatus string    `json:"today_status,omitempty"`
+	ID                int64     `json:"id"`
+	UserID            int64     `json:"user_id"`
+	Title             string    `json:"title"`
+	Description       string    `json:"description"`
+	Frequency         string    `json:"frequency"` // daily, weekly, custom
+	Goal              string    `json:"goal"`
+	TimesPerWeek      int       `json:"times_per_week,omitempty"` // custom: e.g. 3 for "3 times/week"
+	Weekdays          Weekdays  `json:"weekdays,omitempty"`       // custom: specific days, e.g. Mon/Wed/Fri
+	TargetValue       float64   `json:"target_value,omitempty"`   // e.g. 2 for "drink 2 L of water", 0 if not quantitative
+	Unit              string    `json:"unit,omitempty"`           // e.g. "L", "pages"
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	TodayStatus       string    `json:"today_status,omitempty"`
+	TodayValue        float64   `json:"today_value,omitempty"`
+	DueToday          bool      `json:"due_today,omitempty"`
+	CompletedThisWeek int       `json:"completed_this_week,omitempty"` // for habits done N times per week
+	Streak            Streak    `json:"streak"`
 }
 
+// PermittedFrequencies lists the frequencies a habit can have.
+var PermittedFrequencies = []string{"daily", "weekly", "custom"}
+
 func ValidateHabit(v *validator.Validator, h *Habit) {
 	v.Check(validator.NotBlank(h.Title), "title", "must be provided")
 	v.Check(validator.MaxLength(h.Title, 255), "title", "must not be more than 255 characters")
 
 	v.Check(validator.NotBlank(h.Frequency), "frequency", "must be provided")
-	v.Check(validator.PermittedValue(h.Frequency, "daily", "weekly"), "frequency", "must be 'daily' or 'weekly'")
+	v.Check(validator.PermittedValue(h.Frequency, PermittedFrequencies...), "frequency", "must be 'daily', 'weekly' or 'custom'")
+
+	if h.Frequency == "custom" {
+		v.Check(h.TimesPerWeek >= 0 && h.TimesPerWeek <= 7, "times_per_week", "must not be more than 7")
+		v.Check(h.TimesPerWeek > 0 || h.Weekdays != 0, "schedule", "choose specific weekdays or a number of times per week")
+		v.Check(h.TimesPerWeek == 0 || h.Weekdays == 0, "schedule", "choose either specific weekdays or times per week, not both")
+	} else {
+		v.Check(h.TimesPerWeek == 0 && h.Weekdays == 0, "schedule", "only custom habits can have a schedule")
+	}
 
 	v.Check(validator.NotBlank(h.Description), "description", "must be provided")
 	v.Check(validator.MaxLength(h.Description, 1000), "description", "must not be more than 1000 characters")
 
 	v.Check(validator.NotBlank(h.Goal), "goal", "must be provided")
 	v.Check(validator.MaxLength(h.Goal, 100), "goal", "must not be more than 100 characters")
+
+	v.Check(h.TargetValue >= 0, "target_value", "must not be negative")
+	v.Check(belowMaxValue(h.TargetValue), "target_value", "must be less than 100000000")
+	v.Check(validator.MaxLength(h.Unit, 20), "unit", "must not be more than 20 characters")
+	if h.TargetValue > 0 {
+		v.Check(validator.NotBlank(h.Unit), "unit", "must be provided when a target is set")
+	} else {
+		v.Check(!validator.NotBlank(h.Unit), "unit", "requires a target")
+	}
+}
+
+// IsQuantitative reports whether the habit is tracked against a numeric target.
+func (h *Habit) IsQuantitative() bool {
+	return h.TargetValue > 0
+}
+
+// Completion returns how much of the target the given value achieves, from 0 to 1.
+// Habits without a target are either not done (0) or done (1).
+func (h *Habit) Completion(value float64) float64 {
+	if !h.IsQuantitative() {
+		if value > 0 {
+			return 1
+		}
+		return 0
+	}
+	return min(max(value/h.TargetValue, 0), 1)
+}
+
+// TodayPercent is today's completion as a percentage, e.g. 60 for 1.2 of 2 L.
+func (h *Habit) TodayPercent() int {
+	return int(h.Completion(h.TodayValue) * 100)
+}
+
+// TodaySummary describes today's amount against the target, e.g. "1.2/2 L".
+func (h *Habit) TodaySummary() string {
+	return FormatAmount(h.TodayValue) + "/" + h.TargetSummary()
+}
+
+// TargetSummary describes the target for display, e.g. "2 L".
+func (h *Habit) TargetSummary() string {
+	return FormatAmount(h.TargetValue) + " " + h.Unit
+}
+
+// FormatAmount formats a logged amount without trailing zeros, e.g. "1.2".
+func FormatAmount(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
 }
 
 type HabitModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration // for each query; DefaultTimeout if zero
 }
 
 // Insert a new habit
 func (m *HabitModel) Insert(habit *Habit) error {
 	query := `
-		INSERT INTO habits (user_id, title, description, frequency, goal) -- Add user_id
-        VALUES ($1, $2, $3, $4, $5)                                  -- Add $1 for user_id
+		INSERT INTO habits (user_id, title, description, frequency, goal, times_per_week, weekdays, target_value, unit) -- Add user_id
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)                                                                  -- Add $1 for user_id
         RETURNING id, created_at, updated_at`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	return m.DB.QueryRowContext(ctx, query,
@@ -62,18 +140,22 @@ func (m *HabitModel) Insert(habit *Habit) error {
 		habit.Description,
 		habit.Frequency,
 		habit.Goal,
+		habit.TimesPerWeek,
+		habit.Weekdays,
+		habit.TargetValue,
+		habit.Unit,
 	).Scan(&habit.ID, &habit.CreatedAt, &habit.UpdatedAt)
 }
 
 // GetAllByFrequency returns all habits for a given user with matching frequency
 func (m *HabitModel) GetAllByFrequency(userID int64, frequency string) ([]Habit, error) {
 	query := `
-		SELECT id, user_id, title, description, frequency, goal, created_at, updated_at -- Add user_id
+		SELECT id, user_id, title, description, frequency, goal, times_per_week, weekdays, target_value, unit, created_at, updated_at -- Add user_id
 		FROM habits
 		WHERE user_id = $1 AND frequency = $2 -- Filter by user_id and frequency
 		ORDER BY created_at DESC`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userID, frequency) // Pass userID
@@ -93,6 +175,58 @@ func (m *HabitModel) GetAllByFrequency(userID int64, frequency string) ([]Habit,
 			&h.Description,
 			&h.Frequency,
 			&h.Goal,
+			&h.TimesPerWeek,
+			&h.Weekdays,
+			&h.TargetValue,
+			&h.Unit,
+			&h.CreatedAt,
+			&h.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		habits = append(habits, h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return habits, nil
+}
+
+// GetAllByUser returns all habits for a given user, regardless of frequency
+func (m *HabitModel) GetAllByUser(userID int64) ([]Habit, error) {
+	query := `
+		SELECT id, user_id, title, description, frequency, goal, times_per_week, weekdays, target_value, unit, created_at, updated_at
+		FROM habits
+		WHERE user_id = $1
+		ORDER BY created_at DESC`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var habits []Habit
+
+	for rows.Next() {
+		var h Habit
+		err := rows.Scan(
+			&h.ID,
+			&h.UserID,
+			&h.Title,
+			&h.Description,
+			&h.Frequency,
+			&h.Goal,
+			&h.TimesPerWeek,
+			&h.Weekdays,
+			&h.TargetValue,
+			&h.Unit,
 			&h.CreatedAt,
 			&h.UpdatedAt,
 		)
@@ -112,11 +246,11 @@ func (m *HabitModel) GetAllByFrequency(userID int64, frequency string) ([]Habit,
 // GetByID returns a single habit by its ID
 func (m *HabitModel) GetByID(id int64) (*Habit, error) {
 	query := `
-        SELECT id, user_id, title, description, frequency, goal, created_at, updated_at -- Add user_id
+        SELECT id, user_id, title, description, frequency, goal, times_per_week, weekdays, target_value, unit, created_at, updated_at -- Add user_id
         FROM habits
         WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	var habit Habit
@@ -127,6 +261,10 @@ func (m *HabitModel) GetByID(id int64) (*Habit, error) {
 		&habit.Description,
 		&habit.Frequency,
 		&habit.Goal,
+		&habit.TimesPerWeek,
+		&habit.Weekdays,
+		&habit.TargetValue,
+		&habit.Unit,
 		&habit.CreatedAt,
 		&habit.UpdatedAt,
 	)
@@ -145,10 +283,10 @@ func (m *HabitModel) GetByID(id int64) (*Habit, error) {
 func (m *HabitModel) Update(habit *Habit) error {
 	query := `
 		UPDATE habits
-		SET title = $1, description = $2, frequency = $3, goal = $4, updated_at = NOW()
-		WHERE id = $5 AND user_id = $6` // Add user_id to WHERE clause for ownership
+		SET title = $1, description = $2, frequency = $3, goal = $4, times_per_week = $5, weekdays = $6, target_value = $7, unit = $8, updated_at = NOW()
+		WHERE id = $9 AND user_id = $10` // Add user_id to WHERE clause for ownership
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	result, err := m.DB.ExecContext(ctx, query,
@@ -156,6 +294,10 @@ func (m *HabitModel) Update(habit *Habit) error {
 		habit.Description,
 		habit.Frequency,
 		habit.Goal,
+		habit.TimesPerWeek,
+		habit.Weekdays,
+		habit.TargetValue,
+		habit.Unit,
 		habit.ID,
 		habit.UserID, // Pass UserID for the WHERE clause
 	)
@@ -180,7 +322,7 @@ func (m *HabitModel) Update(habit *Habit) error {
 func (m *HabitModel) Delete(id int64, userID int64) error {
 	query := `DELETE FROM habits WHERE id = $1 AND user_id = $2` // Add user_id to WHERE
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	result, err := m.DB.ExecContext(ctx, query, id, userID) // Pass userID
@@ -207,7 +349,7 @@ func (m *HabitModel) Delete(id int64, userID int64) error {
 			updated_at = NOW()
 		WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, id)
@@ -217,12 +359,12 @@ func (m *HabitModel) Delete(id int64, userID int64) error {
 // GetEntries returns all entries for a habit within a date range
 func (m *HabitModel) GetEntries(habitID int64, from, to time.Time) ([]HabitEntry, error) {
 	query := `
-        SELECT id, habit_id, entry_date, status, notes, created_at
+        SELECT id, habit_id, entry_date, status, value, notes, created_at
         FROM habit_entries
         WHERE habit_id = $1 AND entry_date BETWEEN $2 AND $3
         ORDER BY entry_date DESC`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, habitID, from, to)
@@ -239,6 +381,7 @@ func (m *HabitModel) GetEntries(habitID int64, from, to time.Time) ([]HabitEntry
 			&e.HabitID,
 			&e.EntryDate,
 			&e.Status,
+			&e.Value,
 			&e.Notes,
 			&e.CreatedAt,
 		)
@@ -251,20 +394,57 @@ func (m *HabitModel) GetEntries(habitID int64, from, to time.Time) ([]HabitEntry
 	return entries, nil
 }
 
-// LogEntry creates a new habit entry
+// LogEntry records the entry for a habit and date. If the habit already has an
+// entry for that date it is replaced (keeping its notes unless new ones are
+// given) instead of failing on the (habit_id, entry_date) unique constraint.
 func (m *HabitModel) LogEntry(entry *HabitEntry) error {
 	query := `
-        INSERT INTO habit_entries (habit_id, entry_date, status, notes)
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO habit_entries (habit_id, entry_date, status, value, notes)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (habit_id, entry_date) DO UPDATE
+        SET status = EXCLUDED.status,
+            value = EXCLUDED.value,
+            notes = COALESCE(NULLIF(EXCLUDED.notes, ''), habit_entries.notes)
         RETURNING id, created_at`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	return m.DB.QueryRowContext(ctx, query,
 		entry.HabitID,
 		entry.EntryDate,
 		entry.Status,
+		entry.Value,
 		entry.Notes,
 	).Scan(&entry.ID, &entry.CreatedAt)
 }
+
+// AddAmount adds entry.Value to the amount already logged for the entry's date,
+// creating the entry if needed. The status becomes "completed" once the total
+// reaches the habit's target and "partial" until then. On return the entry
+// holds the accumulated value and resulting status. The total stops at the
+// largest value the column holds rather than overflowing it.
+func (m *HabitModel) AddAmount(entry *HabitEntry, target float64) error {
+	const maxTotal = MaxValue - 0.01
+
+	query := `
+        INSERT INTO habit_entries (habit_id, entry_date, status, value, notes)
+        VALUES ($1, $2, CASE WHEN LEAST($3::numeric, $6::numeric) >= $4::numeric THEN 'completed' ELSE 'partial' END, LEAST($3::numeric, $6::numeric), $5)
+        ON CONFLICT (habit_id, entry_date) DO UPDATE
+        SET value = LEAST(habit_entries.value + EXCLUDED.value, $6::numeric),
+            status = CASE WHEN LEAST(habit_entries.value + EXCLUDED.value, $6::numeric) >= $4::numeric THEN 'completed' ELSE 'partial' END,
+            notes = COALESCE(NULLIF(EXCLUDED.notes, ''), habit_entries.notes)
+        RETURNING id, status, value, created_at`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query,
+		entry.HabitID,
+		entry.EntryDate,
+		entry.Value,
+		target,
+		entry.Notes,
+		maxTotal,
+	).Scan(&entry.ID, &entry.Status, &entry.Value, &entry.CreatedAt)
+}
@@ -1,55 +1,91 @@
 package data
 
 import (
-	"context"
 	"database/sql"
 	"errors"
+	"math"
 	"time"
+
+	"github.com/amari03/habit-tracker/internal/validator"
+	"github.com/lib/pq"
 )
 
 type HabitEntry struct {
 	ID        int64     `json:"id"`
 	HabitID   int64     `json:"habit_id"`
 	EntryDate time.Time `json:"entry_date"`
-	Status    string    `json:"status"` // e.g., "completed", "skipped", "missed"
+	Status    string    `json:"status"`          // e.g., "completed", "partial", "skipped", "missed"
+	Value     float64   `json:"value,omitempty"` // Amount logged for quantitative habits
 	Notes     string    `json:"notes,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// MaxValue bounds targets and logged amounts, which are stored as
+// NUMERIC(10,2).
+const MaxValue = 100000000
+
+// belowMaxValue reports whether x is less than MaxValue once it's rounded to
+// the column's two decimal places.
+func belowMaxValue(x float64) bool {
+	return math.Round(x*100) < MaxValue*100
+}
+
+// PermittedEntryStatuses lists the statuses an entry can have.
+var PermittedEntryStatuses = []string{"completed", "partial", "skipped", "missed"}
+
+func ValidateHabitEntry(v *validator.Validator, e *HabitEntry) {
+	v.Check(validator.NotBlank(e.Status), "status", "must be provided")
+	v.Check(validator.PermittedValue(e.Status, PermittedEntryStatuses...), "status", "must be 'completed', 'partial', 'skipped' or 'missed'")
+
+	v.Check(!math.IsNaN(e.Value) && !math.IsInf(e.Value, 0), "value", "must be a number")
+	v.Check(e.Value >= 0, "value", "must not be negative")
+	v.Check(belowMaxValue(e.Value), "value", "must be less than 100000000")
+	v.Check(validator.MaxLength(e.Notes, 1000), "notes", "must not be more than 1000 characters")
+}
+
+// FormattedValue formats the logged amount for display, e.g. "1.2".
+func (e HabitEntry) FormattedValue() string {
+	return FormatAmount(e.Value)
+}
+
 type HabitEntryModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration // for each query; DefaultTimeout if zero
 }
 
 // Insert a new habit entry
 func (m *HabitEntryModel) Insert(entry *HabitEntry) error {
 	query := `
-		INSERT INTO habit_entries (habit_id, entry_date, status, notes)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO habit_entries (habit_id, entry_date, status, value, notes)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id, created_at`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	return m.DB.QueryRowContext(ctx, query,
 		entry.HabitID,
 		entry.EntryDate,
 		entry.Status,
+		entry.Value,
 		entry.Notes,
 	).Scan(&entry.ID, &entry.CreatedAt)
 }
 
-func (m *HabitEntryModel) GetTodayStatus(habitID int64) (string, error) {
+// GetTodayStatus returns the status logged for the given day, where today is
+// the user's local date (see User.Today) rather than the database's CURRENT_DATE.
+func (m *HabitEntryModel) GetTodayStatus(habitID int64, today time.Time) (string, error) {
 	query := `
         SELECT status 
         FROM habit_entries 
-        WHERE habit_id = $1 AND entry_date = CURRENT_DATE
+        WHERE habit_id = $1 AND entry_date = $2
         LIMIT 1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	var status string
-	err := m.DB.QueryRowContext(ctx, query, habitID).Scan(&status)
+	err := m.DB.QueryRowContext(ctx, query, habitID, today.Format("2006-01-02")).Scan(&status)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return "", nil
@@ -59,15 +95,75 @@ func (m *HabitEntryModel) GetTodayStatus(habitID int64) (string, error) {
 	return status, nil
 }
 
+// Get returns a single habit entry by its ID
+func (m *HabitEntryModel) Get(id int64) (*HabitEntry, error) {
+	query := `
+        SELECT id, habit_id, entry_date, status, value, notes, created_at
+        FROM habit_entries
+        WHERE id = $1`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	var entry HabitEntry
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&entry.ID,
+		&entry.HabitID,
+		&entry.EntryDate,
+		&entry.Status,
+		&entry.Value,
+		&entry.Notes,
+		&entry.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// GetByDate returns the entry for a habit on the given date
+func (m *HabitEntryModel) GetByDate(habitID int64, date time.Time) (*HabitEntry, error) {
+	query := `
+        SELECT id, habit_id, entry_date, status, value, notes, created_at
+        FROM habit_entries
+        WHERE habit_id = $1 AND entry_date = $2`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	var entry HabitEntry
+	err := m.DB.QueryRowContext(ctx, query, habitID, date).Scan(
+		&entry.ID,
+		&entry.HabitID,
+		&entry.EntryDate,
+		&entry.Status,
+		&entry.Value,
+		&entry.Notes,
+		&entry.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
 // Get all entries for a specific habit
 func (m *HabitEntryModel) GetByHabitID(habitID int64) ([]HabitEntry, error) {
 	query := `
-		SELECT id, habit_id, entry_date, status, notes, created_at
+		SELECT id, habit_id, entry_date, status, value, notes, created_at
 		FROM habit_entries
 		WHERE habit_id = $1
 		ORDER BY entry_date DESC`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, habitID)
@@ -84,6 +180,7 @@ func (m *HabitEntryModel) GetByHabitID(habitID int64) ([]HabitEntry, error) {
 			&entry.HabitID,
 			&entry.EntryDate,
 			&entry.Status,
+			&entry.Value,
 			&entry.Notes,
 			&entry.CreatedAt,
 		)
@@ -104,15 +201,16 @@ func (m *HabitEntryModel) GetByHabitID(habitID int64) ([]HabitEntry, error) {
 func (m *HabitEntryModel) Update(entry *HabitEntry) error {
 	query := `
         UPDATE habit_entries
-        SET status = $1, notes = $2
-        WHERE id = $3
+        SET status = $1, value = $2, notes = $3
+        WHERE id = $4
         RETURNING entry_date`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	return m.DB.QueryRowContext(ctx, query,
 		entry.Status,
+		entry.Value,
 		entry.Notes,
 		entry.ID,
 	).Scan(&entry.EntryDate)
@@ -122,7 +220,7 @@ func (m *HabitEntryModel) Update(entry *HabitEntry) error {
 func (m *HabitEntryModel) Delete(id int64) error {
 	query := `DELETE FROM habit_entries WHERE id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, id)
@@ -138,7 +236,7 @@ func (m *HabitEntryModel) GetCompletionRate(habitID int64) (float64, error) {
 		FROM habit_entries
 		WHERE habit_id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	var rate sql.NullFloat64
@@ -152,19 +250,54 @@ func (m *HabitEntryModel) GetCompletionRate(habitID int64) (float64, error) {
 	return 0, nil
 }
 
+// GetByHabitIDs returns the date and status of every entry for the given habits,
+// grouped by habit and ordered by date. Used to calculate streaks in bulk.
+func (m *HabitEntryModel) GetByHabitIDs(habitIDs []int64) (map[int64][]HabitEntry, error) {
+	query := `
+        SELECT habit_id, entry_date, status
+        FROM habit_entries
+        WHERE habit_id = ANY($1)
+        ORDER BY habit_id, entry_date`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(habitIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int64][]HabitEntry)
+	for rows.Next() {
+		var entry HabitEntry
+		if err := rows.Scan(&entry.HabitID, &entry.EntryDate, &entry.Status); err != nil {
+			return nil, err
+		}
+		result[entry.HabitID] = append(result[entry.HabitID], entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
 // Add this new method for bulk operations
-func (m *HabitEntryModel) GetRecentCompletions(habitIDs []int64) (map[int64]bool, error) {
+// GetRecentCompletions reports which of the habits were completed on the user's local date today.
+func (m *HabitEntryModel) GetRecentCompletions(habitIDs []int64, today time.Time) (map[int64]bool, error) {
 	query := `
         SELECT DISTINCT habit_id
         FROM habit_entries
         WHERE habit_id = ANY($1) 
         AND status = 'completed'
-        AND entry_date = CURRENT_DATE`
+        AND entry_date = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, habitIDs)
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(habitIDs), today.Format("2006-01-02"))
 	if err != nil {
 		return nil, err
 	}
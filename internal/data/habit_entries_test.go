@@ -0,0 +1,34 @@
+package data
+
+import (
+	"math"
+	"testing"
+
+	"github.com/amari03/habit-tracker/internal/validator"
+)
+
+func TestValidateHabitEntry(t *testing.T) {
+	tests := []struct {
+		value float64
+		valid bool
+	}{
+		{0, true},
+		{2.5, true},
+		{99999999.99, true},
+		{-1, false},
+		{100000000, false},
+		{99999999.995, false}, // rounds up to 100000000.00
+		{1e300, false},
+		{math.NaN(), false},
+		{math.Inf(1), false},
+		{math.Inf(-1), false},
+	}
+
+	for _, tt := range tests {
+		v := validator.NewValidator()
+		ValidateHabitEntry(v, &HabitEntry{Status: "completed", Value: tt.value})
+		if v.ValidData() != tt.valid {
+			t.Errorf("ValidateHabitEntry(value %v) valid = %v, want %v", tt.value, v.ValidData(), tt.valid)
+		}
+	}
+}
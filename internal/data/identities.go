@@ -0,0 +1,51 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// UserIdentityModel links users to their accounts at OpenID Connect
+// providers, identified by the provider's issuer URL and its subject ID for
+// the user.
+type UserIdentityModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // for each query; DefaultTimeout if zero
+}
+
+// GetUserID returns the ID of the user linked to the provider's account.
+func (m *UserIdentityModel) GetUserID(issuer, subject string) (int64, error) {
+	query := `
+        SELECT user_id
+        FROM user_identities
+        WHERE issuer = $1 AND subject = $2`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	var userID int64
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrRecordNotFound
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+// Link links the provider's account to the user. Linking an account that is
+// already linked does nothing.
+func (m *UserIdentityModel) Link(issuer, subject string, userID int64) error {
+	query := `
+        INSERT INTO user_identities (issuer, subject, user_id)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (issuer, subject) DO NOTHING`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, issuer, subject, userID)
+	return err
+}
@@ -0,0 +1,192 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// ImportedHabit is a habit read from another tracker's export, together with
+// its history. PlanImport fills in ExistingID and Conflicts.
+type ImportedHabit struct {
+	Habit      Habit
+	Entries    []HabitEntry
+	ExistingID int64       // the user's matching habit, 0 if a new habit will be created
+	Conflicts  []time.Time // dates that already have an entry in the matching habit
+}
+
+// ImportSummary reports what an import changed.
+type ImportSummary struct {
+	HabitsCreated   int
+	EntriesImported int
+	EntriesSkipped  int // conflicting entries left as they were
+}
+
+// ImportTimeout is how long saving an import can take. Years of history can
+// mean thousands of rows, so it's far longer than a normal query.
+const ImportTimeout = time.Minute
+
+// ImportModel matches imported habits against the user's own and saves them.
+type ImportModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // for the dry run; DefaultTimeout if zero
+}
+
+// importKey identifies a habit for matching: an imported habit is merged into
+// an existing one with the same title (ignoring case) and frequency.
+func importKey(title, frequency string) string {
+	return strings.ToLower(strings.TrimSpace(title)) + "\x00" + frequency
+}
+
+// PlanImport is the dry run of an import. It matches each imported habit to
+// one the user already has and lists the entry dates that clash with the
+// (habit_id, entry_date) uniqueness constraint. Nothing is written.
+func (m *ImportModel) PlanImport(userID int64, habits []ImportedHabit) error {
+	query := `
+        SELECT h.id, h.title, h.frequency, e.entry_date
+        FROM habits h
+        LEFT JOIN habit_entries e ON e.habit_id = h.id
+        WHERE h.user_id = $1
+        ORDER BY h.id, e.entry_date`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	existingIDs := make(map[string]int64)
+	existingDates := make(map[int64]map[string]bool)
+	for rows.Next() {
+		var id int64
+		var title, frequency string
+		var entryDate sql.NullTime
+		err := rows.Scan(&id, &title, &frequency, &entryDate)
+		if err != nil {
+			return err
+		}
+
+		key := importKey(title, frequency)
+		if _, ok := existingIDs[key]; !ok {
+			existingIDs[key] = id
+		}
+		if existingDates[id] == nil {
+			existingDates[id] = make(map[string]bool)
+		}
+		if entryDate.Valid {
+			existingDates[id][entryDate.Time.Format("2006-01-02")] = true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range habits {
+		h := &habits[i]
+		h.ExistingID = existingIDs[importKey(h.Habit.Title, h.Habit.Frequency)]
+		h.Conflicts = nil
+		if h.ExistingID == 0 {
+			continue
+		}
+		for _, entry := range h.Entries {
+			if existingDates[h.ExistingID][entry.EntryDate.Format("2006-01-02")] {
+				h.Conflicts = append(h.Conflicts, entry.EntryDate)
+			}
+		}
+	}
+	return nil
+}
+
+// Import saves a planned import in a single transaction: new habits are
+// created, and entries are added to new and matched habits alike. Entries
+// that clash with an existing one replace it if overwrite is set and are
+// skipped otherwise. If anything fails, nothing is saved.
+func (m *ImportModel) Import(userID int64, habits []ImportedHabit, overwrite bool) (*ImportSummary, error) {
+	insertHabit := `
+        INSERT INTO habits (user_id, title, description, frequency, goal, times_per_week, weekdays, target_value, unit)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        RETURNING id`
+
+	insertEntry := `
+        INSERT INTO habit_entries (habit_id, entry_date, status, value, notes)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (habit_id, entry_date) DO NOTHING`
+	if overwrite {
+		insertEntry = `
+            INSERT INTO habit_entries (habit_id, entry_date, status, value, notes)
+            VALUES ($1, $2, $3, $4, $5)
+            ON CONFLICT (habit_id, entry_date) DO UPDATE
+            SET status = EXCLUDED.status,
+                value = EXCLUDED.value,
+                notes = COALESCE(NULLIF(EXCLUDED.notes, ''), habit_entries.notes)`
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ImportTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, insertEntry)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var summary ImportSummary
+	for _, h := range habits {
+		habitID := h.ExistingID
+		if habitID == 0 {
+			err = tx.QueryRowContext(ctx, insertHabit,
+				userID,
+				h.Habit.Title,
+				h.Habit.Description,
+				h.Habit.Frequency,
+				h.Habit.Goal,
+				h.Habit.TimesPerWeek,
+				h.Habit.Weekdays,
+				h.Habit.TargetValue,
+				h.Habit.Unit,
+			).Scan(&habitID)
+			if err != nil {
+				return nil, err
+			}
+			summary.HabitsCreated++
+		}
+
+		for _, entry := range h.Entries {
+			result, err := stmt.ExecContext(ctx,
+				habitID,
+				entry.EntryDate.Format("2006-01-02"),
+				entry.Status,
+				entry.Value,
+				entry.Notes,
+			)
+			if err != nil {
+				return nil, err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				summary.EntriesSkipped++
+			} else {
+				summary.EntriesImported++
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
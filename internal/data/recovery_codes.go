@@ -0,0 +1,133 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"strings"
+	"time"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets at a time
+const recoveryCodeCount = 10
+
+// RecoveryCodeModel stores the one-time codes that let a user with two-factor
+// authentication log in without their authenticator app. Only the hashes are
+// stored, so the codes are shown once when they are generated.
+type RecoveryCodeModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // for each query; DefaultTimeout if zero
+}
+
+// generateRecoveryCode returns a random code like "k3xq-7mfa-p2dn-c6wz".
+func generateRecoveryCode() (string, error) {
+	randomBytes := make([]byte, 10)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(randomBytes))
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+// hashRecoveryCode hashes a code as typed, ignoring case, spaces and dashes.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+// Replace throws away the user's recovery codes and generates a new set,
+// returning the plaintext codes.
+func (m *RecoveryCodeModel) Replace(userID int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (hash, user_id) VALUES ($1, $2)`,
+			hashRecoveryCode(code), userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Use deletes the recovery code if it belongs to the user, reporting whether
+// it did. Each code works once.
+func (m *RecoveryCodeModel) Use(userID int64, code string) (bool, error) {
+	query := `
+        DELETE FROM recovery_codes
+        WHERE hash = $1 AND user_id = $2`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hashRecoveryCode(code), userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// Remaining returns how many unused recovery codes the user has.
+func (m *RecoveryCodeModel) Remaining(userID int64) (int, error) {
+	query := `
+        SELECT COUNT(*)
+        FROM recovery_codes
+        WHERE user_id = $1`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// DeleteAllForUser removes the user's recovery codes, e.g. when they turn
+// two-factor authentication off.
+func (m *RecoveryCodeModel) DeleteAllForUser(userID int64) error {
+	query := `
+        DELETE FROM recovery_codes
+        WHERE user_id = $1`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
@@ -0,0 +1,154 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnknownWeekday = errors.New("unknown weekday")
+
+// Weekdays is a bitmask of the days a custom habit is scheduled on.
+// Bit n is set when time.Weekday(n) is scheduled (Sunday = 1, Monday = 2, ...).
+type Weekdays uint8
+
+// weekdayNames are the short names used in forms and summaries, indexed by time.Weekday.
+var weekdayNames = [7]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// NewWeekdays builds a bitmask from the given days.
+func NewWeekdays(days ...time.Weekday) Weekdays {
+	var w Weekdays
+	for _, d := range days {
+		w |= 1 << uint(d)
+	}
+	return w
+}
+
+// ParseWeekdays converts submitted form values ("mon", "Tue", "3", ...) into a bitmask.
+func ParseWeekdays(values []string) (Weekdays, error) {
+	var w Weekdays
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		found := false
+		for i, name := range weekdayNames {
+			if strings.EqualFold(value, name) || value == strconv.Itoa(i) {
+				w |= 1 << uint(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, ErrUnknownWeekday
+		}
+	}
+	return w, nil
+}
+
+// Has reports whether the given day is part of the schedule.
+func (w Weekdays) Has(d time.Weekday) bool {
+	return w&(1<<uint(d)) != 0
+}
+
+// Days returns the scheduled days, starting from Sunday.
+func (w Weekdays) Days() []time.Weekday {
+	var days []time.Weekday
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if w.Has(d) {
+			days = append(days, d)
+		}
+	}
+	return days
+}
+
+// String returns the scheduled days as e.g. "Mon, Wed, Fri".
+func (w Weekdays) String() string {
+	var names []string
+	for _, d := range w.Days() {
+		names = append(names, weekdayNames[d])
+	}
+	return strings.Join(names, ", ")
+}
+
+// MarshalJSON encodes the schedule as a list of day names, e.g. ["mon","wed","fri"].
+func (w Weekdays) MarshalJSON() ([]byte, error) {
+	names := []string{}
+	for _, d := range w.Days() {
+		names = append(names, strings.ToLower(weekdayNames[d]))
+	}
+	return json.Marshal(names)
+}
+
+// UnmarshalJSON accepts the same day names and numbers as ParseWeekdays.
+func (w *Weekdays) UnmarshalJSON(b []byte) error {
+	var values []string
+	err := json.Unmarshal(b, &values)
+	if err != nil {
+		return err
+	}
+	*w, err = ParseWeekdays(values)
+	return err
+}
+
+// DueOn reports whether the habit is expected to be done on the given date.
+// Daily and weekly habits can be done on any day; custom habits with specific
+// weekdays are only due on those days.
+func (h *Habit) DueOn(date time.Time) bool {
+	if h.Frequency == "custom" && h.Weekdays != 0 {
+		return h.Weekdays.Has(date.Weekday())
+	}
+	return true
+}
+
+// ScheduleSummary describes the habit's schedule for display.
+func (h *Habit) ScheduleSummary() string {
+	switch {
+	case h.Frequency == "daily":
+		return "Every day"
+	case h.Frequency == "weekly":
+		return "Once a week"
+	case h.Weekdays != 0:
+		return h.Weekdays.String()
+	case h.TimesPerWeek == 1:
+		return "1 time a week"
+	case h.TimesPerWeek > 1:
+		return strconv.Itoa(h.TimesPerWeek) + " times a week"
+	default:
+		return h.Frequency
+	}
+}
+
+// ParseWeekday converts a day name such as "monday" or "Sun" into a time.Weekday.
+func ParseWeekday(name string) (time.Weekday, error) {
+	name = strings.TrimSpace(name)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(name, d.String()) || strings.EqualFold(name, weekdayNames[d]) {
+			return d, nil
+		}
+	}
+	return 0, ErrUnknownWeekday
+}
+
+// StartOfWeek returns midnight of the first day of the week containing the
+// given date, where weeks begin on weekStart (time.Monday for ISO weeks).
+func StartOfWeek(date time.Time, weekStart time.Weekday) time.Time {
+	offset := (int(date.Weekday()) - int(weekStart) + 7) % 7 // days since the week started
+	y, m, d := date.AddDate(0, 0, -offset).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, date.Location())
+}
+
+// PeriodStart returns the date entries for the given day are logged against:
+// the start of the week for weekly habits, so each week has a single entry,
+// and the day itself for everything else.
+func (h *Habit) PeriodStart(date time.Time, weekStart time.Weekday) time.Time {
+	if h.Frequency == "weekly" {
+		return StartOfWeek(date, weekStart)
+	}
+	y, m, d := date.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, date.Location())
+}
@@ -0,0 +1,237 @@
+package data
+
+import (
+	"database/sql"
+	"math"
+	"time"
+)
+
+// HabitCompletion is a habit's completion rate, as a percentage, over the last
+// 7, 30 and 90 days.
+type HabitCompletion struct {
+	HabitID   int64  `json:"habit_id"`
+	Title     string `json:"title"`
+	Frequency string `json:"frequency"`
+	Last7     int    `json:"last_7_days"`
+	Last30    int    `json:"last_30_days"`
+	Last90    int    `json:"last_90_days"`
+}
+
+// WeekdayScore is how often habits due on a given weekday were completed.
+type WeekdayScore struct {
+	Weekday   time.Weekday `json:"weekday"`
+	Due       int          `json:"due"`
+	Completed int          `json:"completed"`
+	Percent   int          `json:"percent"`
+}
+
+// DailyScore is the share of the habits due on a date that were completed.
+type DailyScore struct {
+	Date      time.Time `json:"date"`
+	Due       int       `json:"due"`
+	Completed int       `json:"completed"`
+	Percent   int       `json:"percent"`
+}
+
+// StatsModel runs the aggregate queries behind the statistics dashboard.
+type StatsModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // for each query; DefaultTimeout if zero
+}
+
+// statsHabits lists the user's habits with the date tracking started: the day
+// the habit was created, or its first entry if that was backfilled earlier.
+// Days before then don't count against the habit.
+const statsHabits = `
+        user_habits AS (
+            SELECT h.id, h.title, h.frequency, h.times_per_week, h.weekdays,
+                LEAST(h.created_at::date, (SELECT MIN(entry_date) FROM habit_entries WHERE habit_id = h.id)) AS since
+            FROM habits h
+            WHERE h.user_id = $1
+        )`
+
+// statsDueOnSpecificDay matches the habits that are due on d.day itself: daily
+// habits and custom habits scheduled on that weekday. Weekly and N-times-per-week
+// habits can be done on any day of the week, so they aren't due on any one day.
+const statsDueOnSpecificDay = `
+        (uh.frequency = 'daily'
+            OR (uh.frequency = 'custom' AND uh.weekdays & (1 << EXTRACT(DOW FROM d.day)::int) <> 0))`
+
+// CompletionRates returns the completion rate of each of the user's habits over
+// the last 7, 30 and 90 days up to and including today. The expected number of
+// completions follows the habit's schedule: one per day for daily habits, one
+// per scheduled day for custom weekday habits, and a share of the weekly quota
+// for each day of weekly and N-times-per-week habits.
+func (m *StatsModel) CompletionRates(userID int64, today time.Time) ([]HabitCompletion, error) {
+	query := `
+        WITH days AS (
+            SELECT day::date FROM generate_series($2::date - 89, $2::date, interval '1 day') AS day
+        ),` + statsHabits + `,
+        expected AS (
+            SELECT uh.id, uh.title, uh.frequency, d.day, e.status,
+                CASE
+                    WHEN uh.frequency = 'custom' AND uh.weekdays <> 0 THEN
+                        CASE WHEN uh.weekdays & (1 << EXTRACT(DOW FROM d.day)::int) <> 0 THEN 1.0 ELSE 0.0 END
+                    WHEN uh.frequency = 'custom' AND uh.times_per_week > 0 THEN uh.times_per_week / 7.0
+                    WHEN uh.frequency = 'weekly' THEN 1 / 7.0
+                    ELSE 1.0
+                END AS due
+            FROM user_habits uh
+            JOIN days d ON d.day >= uh.since
+            LEFT JOIN habit_entries e ON e.habit_id = uh.id AND e.entry_date = d.day
+        )
+        SELECT id, title, frequency,
+            COUNT(*) FILTER (WHERE status = 'completed' AND day > $2::date - 7),
+            COALESCE(SUM(due) FILTER (WHERE day > $2::date - 7), 0),
+            COUNT(*) FILTER (WHERE status = 'completed' AND day > $2::date - 30),
+            COALESCE(SUM(due) FILTER (WHERE day > $2::date - 30), 0),
+            COUNT(*) FILTER (WHERE status = 'completed'),
+            COALESCE(SUM(due), 0)
+        FROM expected
+        GROUP BY id, title, frequency
+        ORDER BY title`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, today.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []HabitCompletion
+	for rows.Next() {
+		var c HabitCompletion
+		var done7, done30, done90 int
+		var due7, due30, due90 float64
+		err := rows.Scan(&c.HabitID, &c.Title, &c.Frequency, &done7, &due7, &done30, &due30, &done90, &due90)
+		if err != nil {
+			return nil, err
+		}
+		c.Last7 = percentOf(float64(done7), due7)
+		c.Last30 = percentOf(float64(done30), due30)
+		c.Last90 = percentOf(float64(done90), due90)
+		rates = append(rates, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+// WeekdayScores returns, for each day of the week starting from Sunday, how
+// often the habits due on that day were completed over the 90 days before
+// today. Today is left out because it isn't over yet, and skipped days are
+// left out because they were deliberate rest days.
+func (m *StatsModel) WeekdayScores(userID int64, today time.Time) ([]WeekdayScore, error) {
+	query := `
+        WITH days AS (
+            SELECT day::date FROM generate_series($2::date - 90, $2::date - 1, interval '1 day') AS day
+        ),` + statsHabits + `
+        SELECT EXTRACT(DOW FROM d.day)::int AS weekday,
+            COUNT(*) FILTER (WHERE e.status IS DISTINCT FROM 'skipped'),
+            COUNT(*) FILTER (WHERE e.status = 'completed')
+        FROM user_habits uh
+        JOIN days d ON d.day >= uh.since
+        LEFT JOIN habit_entries e ON e.habit_id = uh.id AND e.entry_date = d.day
+        WHERE` + statsDueOnSpecificDay + `
+        GROUP BY weekday`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, today.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := make([]WeekdayScore, 7)
+	for d := range scores {
+		scores[d].Weekday = time.Weekday(d)
+	}
+	for rows.Next() {
+		var weekday, due, completed int
+		err := rows.Scan(&weekday, &due, &completed)
+		if err != nil {
+			return nil, err
+		}
+		scores[weekday].Due = due
+		scores[weekday].Completed = completed
+		scores[weekday].Percent = percentOf(float64(completed), float64(due))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// BestAndWorstWeekday picks the weekdays with the highest and lowest completion
+// rates. ok is false when nothing has been due yet.
+func BestAndWorstWeekday(scores []WeekdayScore) (best, worst WeekdayScore, ok bool) {
+	for _, s := range scores {
+		if s.Due == 0 {
+			continue
+		}
+		if !ok || s.Percent > best.Percent {
+			best = s
+		}
+		if !ok || s.Percent < worst.Percent {
+			worst = s
+		}
+		ok = true
+	}
+	return best, worst, ok
+}
+
+// DailyScores returns the overall score for each of the last n days, oldest
+// first and including today: the percentage of the habits due that day which
+// were completed. Skipped habits aren't counted as due.
+func (m *StatsModel) DailyScores(userID int64, today time.Time, n int) ([]DailyScore, error) {
+	query := `
+        WITH days AS (
+            SELECT day::date FROM generate_series($2::date - ($3::int - 1), $2::date, interval '1 day') AS day
+        ),` + statsHabits + `
+        SELECT d.day,
+            COUNT(uh.id) FILTER (WHERE e.status IS DISTINCT FROM 'skipped'),
+            COUNT(uh.id) FILTER (WHERE e.status = 'completed')
+        FROM days d
+        LEFT JOIN user_habits uh ON d.day >= uh.since AND` + statsDueOnSpecificDay + `
+        LEFT JOIN habit_entries e ON e.habit_id = uh.id AND e.entry_date = d.day
+        GROUP BY d.day
+        ORDER BY d.day`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, today.Format("2006-01-02"), n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scores []DailyScore
+	for rows.Next() {
+		var s DailyScore
+		err := rows.Scan(&s.Date, &s.Due, &s.Completed)
+		if err != nil {
+			return nil, err
+		}
+		s.Percent = percentOf(float64(s.Completed), float64(s.Due))
+		scores = append(scores, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// percentOf returns done as a whole percentage of due, capped at 100 so that
+// overachieving a weekly quota doesn't push a rate past 100%.
+func percentOf(done, due float64) int {
+	if due <= 0 {
+		return 0
+	}
+	return int(math.Round(min(done/due, 1) * 100))
+}
@@ -0,0 +1,116 @@
+package data
+
+import (
+	"time"
+)
+
+// Streak holds the current and longest runs of consecutive completed periods
+// for a habit. A period is a day for daily habits, a scheduled day for custom
+// habits with specific weekdays, and a week for weekly and N-times-per-week habits.
+type Streak struct {
+	Current int `json:"current"`
+	Longest int `json:"longest"`
+}
+
+type periodOutcome int
+
+const (
+	periodMissed periodOutcome = iota
+	periodCompleted
+	periodSkipped
+)
+
+// CalculateStreak works out the streak for a habit from its entries.
+//
+// Completed periods extend the streak. Skipped periods are deliberate rest
+// days: they don't extend the streak, but they don't break it either. Periods
+// with no entry (or a "missed" or "partial" entry) break the streak. The period
+// containing today is still open, so not having logged it yet doesn't break
+// the current streak.
+func CalculateStreak(h *Habit, entries []HabitEntry, today time.Time, weekStart time.Weekday) Streak {
+	if len(entries) == 0 {
+		return Streak{}
+	}
+
+	outcomes := periodOutcomes(h, entries, dateOnly(today), weekStart)
+
+	var streak Streak
+	run := 0
+	for i, outcome := range outcomes {
+		switch outcome {
+		case periodCompleted:
+			run++
+			streak.Longest = max(streak.Longest, run)
+		case periodMissed:
+			if i < len(outcomes)-1 {
+				run = 0
+			}
+		}
+	}
+	streak.Current = run
+	return streak
+}
+
+// periodOutcomes returns the outcome of each period from the first entry up to
+// and including the period containing today, oldest first. Weekly periods look
+// at every day of the week, so entries keyed to the start of the week and
+// older entries logged mid-week are both counted.
+func periodOutcomes(h *Habit, entries []HabitEntry, today time.Time, weekStart time.Weekday) []periodOutcome {
+	statuses := make(map[time.Time]string, len(entries))
+	first := today
+	for _, e := range entries {
+		date := dateOnly(e.EntryDate)
+		statuses[date] = e.Status
+		if date.Before(first) {
+			first = date
+		}
+	}
+
+	var outcomes []periodOutcome
+
+	if h.Frequency == "weekly" || (h.Frequency == "custom" && h.TimesPerWeek > 0) {
+		needed := max(h.TimesPerWeek, 1)
+		for week := StartOfWeek(first, weekStart); !week.After(today); week = week.AddDate(0, 0, 7) {
+			var completed, skipped int
+			for day := week; day.Before(week.AddDate(0, 0, 7)); day = day.AddDate(0, 0, 1) {
+				switch statuses[day] {
+				case "completed":
+					completed++
+				case "skipped":
+					skipped++
+				}
+			}
+			switch {
+			case completed >= needed:
+				outcomes = append(outcomes, periodCompleted)
+			case completed == 0 && skipped > 0:
+				outcomes = append(outcomes, periodSkipped)
+			default:
+				outcomes = append(outcomes, periodMissed)
+			}
+		}
+		return outcomes
+	}
+
+	for day := first; !day.After(today); day = day.AddDate(0, 0, 1) {
+		if !h.DueOn(day) {
+			continue
+		}
+		switch statuses[day] {
+		case "completed":
+			outcomes = append(outcomes, periodCompleted)
+		case "skipped":
+			outcomes = append(outcomes, periodSkipped)
+		default:
+			outcomes = append(outcomes, periodMissed)
+		}
+	}
+	return outcomes
+}
+
+// dateOnly strips the time of day, giving a date that can be compared with
+// entry dates read from the database.
+func dateOnly(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,176 @@
+package data
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEntries makes entries from "2006-01-02=status" pairs.
+func testEntries(t *testing.T, pairs ...string) []HabitEntry {
+	t.Helper()
+
+	var entries []HabitEntry
+	for _, pair := range pairs {
+		day, status, _ := strings.Cut(pair, "=")
+		date, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, HabitEntry{EntryDate: date, Status: status})
+	}
+	return entries
+}
+
+func TestCalculateStreak(t *testing.T) {
+	daily := &Habit{Frequency: "daily"}
+	weekly := &Habit{Frequency: "weekly"}
+	twiceAWeek := &Habit{Frequency: "custom", TimesPerWeek: 2}
+	monWedFri := &Habit{Frequency: "custom", Weekdays: NewWeekdays(time.Monday, time.Wednesday, time.Friday)}
+
+	// Weeks start on Monday; 2026-10-12 is a Monday and 2026-10-18 a Sunday
+	tests := []struct {
+		name    string
+		habit   *Habit
+		today   string
+		entries []string
+		want    Streak
+	}{
+		{
+			name:  "daily without entries",
+			habit: daily, today: "2026-10-18",
+			want: Streak{},
+		},
+		{
+			name:  "daily with today still open",
+			habit: daily, today: "2026-10-18",
+			entries: []string{"2026-10-15=completed", "2026-10-16=completed", "2026-10-17=completed"},
+			want:    Streak{Current: 3, Longest: 3},
+		},
+		{
+			name:  "daily with today completed",
+			habit: daily, today: "2026-10-18",
+			entries: []string{"2026-10-17=completed", "2026-10-18=completed"},
+			want:    Streak{Current: 2, Longest: 2},
+		},
+		{
+			name:  "daily broken by a day without an entry",
+			habit: daily, today: "2026-10-18",
+			entries: []string{
+				"2026-10-08=completed", "2026-10-09=completed", "2026-10-10=completed", "2026-10-11=completed",
+				"2026-10-16=completed", "2026-10-17=completed",
+			},
+			want: Streak{Current: 2, Longest: 4},
+		},
+		{
+			name:  "daily broken by a missed or partial day",
+			habit: daily, today: "2026-10-18",
+			entries: []string{
+				"2026-10-13=completed", "2026-10-14=missed", "2026-10-15=completed",
+				"2026-10-16=partial", "2026-10-17=completed",
+			},
+			want: Streak{Current: 1, Longest: 1},
+		},
+		{
+			name:  "daily with a skipped day",
+			habit: daily, today: "2026-10-18",
+			entries: []string{"2026-10-15=completed", "2026-10-16=skipped", "2026-10-17=completed"},
+			want:    Streak{Current: 2, Longest: 2},
+		},
+		{
+			name:  "daily broken yesterday",
+			habit: daily, today: "2026-10-18",
+			entries: []string{"2026-10-15=completed", "2026-10-16=completed"},
+			want:    Streak{Current: 0, Longest: 2},
+		},
+		{
+			name:  "weekly with this week still open",
+			habit: weekly, today: "2026-10-14",
+			entries: []string{"2026-09-28=completed", "2026-10-05=completed"},
+			want:    Streak{Current: 2, Longest: 2},
+		},
+		{
+			name:  "weekly logged mid-week",
+			habit: weekly, today: "2026-10-14",
+			entries: []string{"2026-10-01=completed", "2026-10-09=completed", "2026-10-13=completed"},
+			want:    Streak{Current: 3, Longest: 3},
+		},
+		{
+			name:  "weekly broken by a week without an entry",
+			habit: weekly, today: "2026-10-14",
+			entries: []string{"2026-09-07=completed", "2026-09-14=completed", "2026-09-21=completed", "2026-10-05=completed"},
+			want:    Streak{Current: 1, Longest: 3},
+		},
+		{
+			name:  "weekly with a skipped week",
+			habit: weekly, today: "2026-10-14",
+			entries: []string{"2026-09-21=completed", "2026-09-28=skipped", "2026-10-05=completed"},
+			want:    Streak{Current: 2, Longest: 2},
+		},
+		{
+			name:  "times per week with this week still short",
+			habit: twiceAWeek, today: "2026-10-14",
+			entries: []string{
+				"2026-09-29=completed", "2026-10-02=completed",
+				"2026-10-06=completed", "2026-10-08=completed",
+				"2026-10-13=completed",
+			},
+			want: Streak{Current: 2, Longest: 2},
+		},
+		{
+			name:  "times per week broken by a short week",
+			habit: twiceAWeek, today: "2026-10-18",
+			entries: []string{
+				"2026-09-29=completed", "2026-10-02=completed",
+				"2026-10-06=completed",
+				"2026-10-13=completed", "2026-10-14=completed",
+			},
+			want: Streak{Current: 1, Longest: 1},
+		},
+		{
+			name:  "weekdays ignore the days off",
+			habit: monWedFri, today: "2026-10-15",
+			entries: []string{
+				"2026-10-05=completed", "2026-10-07=completed", "2026-10-09=completed",
+				"2026-10-12=completed", "2026-10-14=completed",
+			},
+			want: Streak{Current: 5, Longest: 5},
+		},
+		{
+			name:  "weekdays broken by a scheduled day without an entry",
+			habit: monWedFri, today: "2026-10-15",
+			entries: []string{
+				"2026-09-28=completed", "2026-09-30=completed", "2026-10-02=completed",
+				"2026-10-05=completed", "2026-10-07=completed",
+				"2026-10-12=completed", "2026-10-14=completed",
+			},
+			want: Streak{Current: 2, Longest: 5},
+		},
+		{
+			name:  "weekdays with today's scheduled day still open",
+			habit: monWedFri, today: "2026-10-16",
+			entries: []string{"2026-10-12=completed", "2026-10-14=completed"},
+			want:    Streak{Current: 2, Longest: 2},
+		},
+		{
+			name:  "weekdays with a skipped scheduled day",
+			habit: monWedFri, today: "2026-10-16",
+			entries: []string{"2026-10-09=completed", "2026-10-12=skipped", "2026-10-14=completed"},
+			want:    Streak{Current: 2, Longest: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			today, err := time.Parse("2006-01-02", tt.today)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := CalculateStreak(tt.habit, testEntries(t, tt.entries...), today, time.Monday)
+			if got != tt.want {
+				t.Errorf("CalculateStreak = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+// Scopes of the single-use tokens sent by email.
+const (
+	ScopeActivation    = "activation"
+	ScopePasswordReset = "password-reset"
+	ScopeEmailChange   = "email-change"
+)
+
+// Token is a single-use token sent to a user by email. Only the hash is
+// stored; Plaintext is filled in when the token is created so it can be put
+// in the email.
+type Token struct {
+	Plaintext string
+	Hash      []byte
+	UserID    int64
+	Expiry    time.Time
+	Scope     string
+}
+
+// generateToken creates a token with a random 26 character plaintext, the
+// same length as API tokens so ValidateTokenPlaintext covers both.
+func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token := &Token{
+		UserID: userID,
+		Expiry: time.Now().Add(ttl),
+		Scope:  scope,
+	}
+
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	return token, nil
+}
+
+type TokenModel struct {
+	DB      *sql.DB
+	Timeout time.Duration // for each query; DefaultTimeout if zero
+}
+
+// New generates a token for the user and stores its hash.
+func (m *TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(token)
+	return token, err
+}
+
+// Insert stores a generated token
+func (m *TokenModel) Insert(token *Token) error {
+	query := `
+        INSERT INTO tokens (hash, user_id, expiry, scope)
+        VALUES ($1, $2, $3, $4)`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope)
+	return err
+}
+
+// DeleteAllForUser removes the user's tokens with the given scope, so a token
+// can't be used again once it has done its job.
+func (m *TokenModel) DeleteAllForUser(scope string, userID int64) error {
+	query := `
+        DELETE FROM tokens
+        WHERE scope = $1 AND user_id = $2`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, userID)
+	return err
+}
+
+// Consume deletes a token with the given scope that hasn't expired and
+// returns the ID of the user it was issued to. Only one of several requests
+// with the same token can consume it, so it can't be used twice at once.
+func (m *TokenModel) Consume(scope, tokenPlaintext string) (int64, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+        DELETE FROM tokens
+        WHERE hash = $1 AND scope = $2 AND expiry > $3
+        RETURNING user_id`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	var userID int64
+	err := m.DB.QueryRowContext(ctx, query, hash[:], scope, time.Now()).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrRecordNotFound
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+// GetUserForToken returns the user a token with the given scope was issued
+// to, as long as it hasn't expired.
+func (m *TokenModel) GetUserForToken(scope, tokenPlaintext string) (*User, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+        SELECT users.id, users.name, users.email, users.created_at, users.password_hash,
+               users.activated, users.timezone, users.day_start_hour, users.session_version,
+               COALESCE(users.pending_email, ''), COALESCE(users.totp_secret, ''), users.has_password
+        FROM users
+        INNER JOIN tokens ON users.id = tokens.user_id
+        WHERE tokens.hash = $1
+        AND tokens.scope = $2
+        AND tokens.expiry > $3`
+
+	var user User
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], scope, time.Now()).Scan(
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.CreatedAt,
+		&user.Password.hash,
+		&user.Active,
+		&user.Timezone,
+		&user.DayStartHour,
+		&user.SessionVersion,
+		&user.PendingEmail,
+		&user.TOTPSecret,
+		&user.HasPassword,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConsumeToken(t *testing.T) {
+	db := testDB(t)
+	users := UserModel{DB: db, Timeout: DefaultTimeout}
+	tokens := TokenModel{DB: db, Timeout: DefaultTimeout}
+
+	// Left over if an earlier run was interrupted
+	if old, err := users.GetByEmail("token-test@example.com"); err == nil {
+		users.Delete(old.ID)
+	}
+
+	user := &User{Name: "Token Test", Email: "token-test@example.com", Active: true, Timezone: "UTC"}
+	err := user.Password.Set("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { users.Delete(user.ID) })
+
+	// Only one of several requests racing with the same token gets it
+	token, err := tokens.New(user.ID, time.Hour, ScopePasswordReset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var consumed int
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userID, err := tokens.Consume(ScopePasswordReset, token.Plaintext)
+			switch {
+			case err == nil:
+				if userID != user.ID {
+					t.Errorf("Consume returned user %d, want %d", userID, user.ID)
+				}
+				mu.Lock()
+				consumed++
+				mu.Unlock()
+			case !errors.Is(err, ErrRecordNotFound):
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if consumed != 1 {
+		t.Errorf("token consumed %d times, want once", consumed)
+	}
+
+	// Expired tokens and tokens for another scope aren't consumed
+	expired, err := tokens.New(user.ID, -time.Minute, ScopePasswordReset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Consume(ScopePasswordReset, expired.Plaintext); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Consume(expired token) = %v, want ErrRecordNotFound", err)
+	}
+	activation, err := tokens.New(user.ID, time.Hour, ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Consume(ScopePasswordReset, activation.Plaintext); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Consume(activation token as a reset token) = %v, want ErrRecordNotFound", err)
+	}
+}
@@ -2,7 +2,6 @@
 package data
 
 import (
-	"context"
 	"database/sql"
 	"errors"
 	"strings" // Needed for duplicate email check
@@ -15,6 +14,7 @@ import (
 var (
 	ErrDuplicateEmail     = errors.New("duplicate email")
 	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrNotActivated       = errors.New("account not activated")
 )
 
 // --- Custom Password Type ---
@@ -49,12 +49,23 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 
 // --- User Struct Definition ---
 type User struct {
-	ID        int64     `json:"id"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"` // Use the custom password type, hide from JSON
-	CreatedAt time.Time `json:"created_at"`
-	Active    bool      `json:"active"` // Keep your 'active' field
+	ID             int64     `json:"id"`
+	Name           string    `json:"name"`
+	Email          string    `json:"email"`
+	Password       password  `json:"-"` // Use the custom password type, hide from JSON
+	CreatedAt      time.Time `json:"created_at"`
+	Active         bool      `json:"active"`         // Keep your 'active' field
+	Timezone       string    `json:"timezone"`       // IANA name, e.g. "America/Belize"
+	DayStartHour   int       `json:"day_start_hour"` // Hour the day rolls over, e.g. 3 for night owls
+	SessionVersion int       `json:"-"`              // Sessions from before the last bump are logged out
+	PendingEmail   string    `json:"-"`              // New address waiting to be confirmed, empty if none
+	TOTPSecret     string    `json:"-"`              // Authenticator app secret, empty unless two-factor is on
+	HasPassword    bool      `json:"-"`              // False for single sign-on accounts until a password is set
+}
+
+// TwoFactorEnabled reports whether logging in needs a code from an authenticator app.
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTPSecret != ""
 }
 
 // --- User Validation ---
@@ -68,9 +79,55 @@ func ValidateUser(v *validator.Validator, u *User) {
 	v.Check(validator.Matches(u.Email, validator.EmailRX), "email", "must be a valid email address")
 }
 
+// ValidatePasswordPlaintext checks a new password chosen at signup or when
+// resetting it.
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	v.Check(validator.NotBlank(password), "password", "Password must be provided")
+	v.Check(validator.MinLength(password, 8), "password", "Password must be at least 8 characters long")
+	v.Check(validator.MaxLength(password, 72), "password", "Password must not be more than 72 characters")
+}
+
+// ValidatePreferences checks the user's time zone and day boundary.
+func ValidatePreferences(v *validator.Validator, u *User) {
+	v.Check(ValidTimezone(u.Timezone), "timezone", "must be a valid time zone, e.g. America/Belize")
+	v.Check(u.DayStartHour >= 0 && u.DayStartHour <= 6, "day_start_hour", "must be between 0 and 6")
+}
+
+// ValidTimezone reports whether name is an IANA time zone. "Local" is
+// refused: it's whatever zone the server happens to run in.
+func ValidTimezone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// Location returns the user's time zone, falling back to UTC if it is unknown.
+func (u *User) Location() *time.Location {
+	if !ValidTimezone(u.Timezone) {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Today returns midnight of the user's current day in their time zone. Until
+// DayStartHour has passed it is still the previous day, so logging at 1 AM
+// with a 3 AM boundary counts towards yesterday.
+func (u *User) Today(now time.Time) time.Time {
+	loc := u.Location()
+	y, m, d := now.In(loc).Add(-time.Duration(u.DayStartHour) * time.Hour).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
 // --- UserModel Struct ---
 type UserModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration // for each query; DefaultTimeout if zero
 }
 
 // --- UserModel Methods ---
@@ -80,8 +137,8 @@ func (m *UserModel) Insert(user *User) error {
 	// Match the column name from your schema diagram: 'password_hash'
 	// Add the 'activated' column based on the example's logic.
 	query := `
-		INSERT INTO users (name, email, password_hash, activated)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO users (name, email, password_hash, activated, timezone, day_start_hour, has_password)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id, created_at`
 
 	args := []any{
@@ -89,9 +146,12 @@ func (m *UserModel) Insert(user *User) error {
 		user.Email,
 		user.Password.hash, // Use the hash from the password struct
 		user.Active,        // Use the Active field
+		user.Timezone,
+		user.DayStartHour,
+		user.HasPassword,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt)
@@ -104,22 +164,24 @@ func (m *UserModel) Insert(user *User) error {
 	return nil
 }
 
-// Authenticate checks email and password for an *active* user.
+// Authenticate checks email and password. Accounts that haven't been
+// activated yet get ErrNotActivated, but only once the password matches.
 func (m *UserModel) Authenticate(email, plaintextPassword string) (int64, error) {
 	var id int64
 	var dbPasswordHash []byte // Variable to store the hash from the DB
+	var activated bool
 
 	query := `
-		SELECT id, password_hash FROM users
-		WHERE email = $1 AND activated = TRUE`
+		SELECT id, password_hash, activated FROM users
+		WHERE email = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, email).Scan(&id, &dbPasswordHash)
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&id, &dbPasswordHash, &activated)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return 0, ErrInvalidCredentials // User not found or not active
+			return 0, ErrInvalidCredentials // User not found
 		}
 		return 0, err // Other DB error
 	}
@@ -133,6 +195,10 @@ func (m *UserModel) Authenticate(email, plaintextPassword string) (int64, error)
 		return 0, err // Other bcrypt error
 	}
 
+	if !activated {
+		return 0, ErrNotActivated
+	}
+
 	// Authentication successful
 	return id, nil
 }
@@ -144,13 +210,14 @@ func (m *UserModel) Get(id int64) (*User, error) { // Renamed from GetUser to Ge
 	}
 
 	query := `
-		SELECT id, name, email, created_at, password_hash, activated
+		SELECT id, name, email, created_at, password_hash, activated, timezone, day_start_hour, session_version,
+		       COALESCE(pending_email, ''), COALESCE(totp_secret, ''), has_password
 		FROM users
 		WHERE id = $1`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	// Scan the results, including the hash into user.Password.hash
@@ -161,6 +228,12 @@ func (m *UserModel) Get(id int64) (*User, error) { // Renamed from GetUser to Ge
 		&user.CreatedAt,
 		&user.Password.hash, // Scan directly into the hash field
 		&user.Active,
+		&user.Timezone,
+		&user.DayStartHour,
+		&user.SessionVersion,
+		&user.PendingEmail,
+		&user.TOTPSecret,
+		&user.HasPassword,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -174,13 +247,14 @@ func (m *UserModel) Get(id int64) (*User, error) { // Renamed from GetUser to Ge
 // GetByEmail retrieves a specific user by Email. (Added from example)
 func (m *UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-        SELECT id, name, email, created_at, password_hash, activated
+        SELECT id, name, email, created_at, password_hash, activated, timezone, day_start_hour, session_version,
+               COALESCE(pending_email, ''), COALESCE(totp_secret, ''), has_password
         FROM users
         WHERE email = $1`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -190,6 +264,12 @@ func (m *UserModel) GetByEmail(email string) (*User, error) {
 		&user.CreatedAt,
 		&user.Password.hash,
 		&user.Active,
+		&user.Timezone,
+		&user.DayStartHour,
+		&user.SessionVersion,
+		&user.PendingEmail,
+		&user.TOTPSecret,
+		&user.HasPassword,
 	)
 
 	if err != nil {
@@ -207,8 +287,10 @@ func (m *UserModel) Update(user *User) error {
 	// Ensure column names ('password_hash', 'activated') match your schema.
 	query := `
         UPDATE users
-        SET name = $1, email = $2, password_hash = $3, activated = $4
-        WHERE id = $5
+        SET name = $1, email = $2, password_hash = $3, activated = $4, timezone = $5, day_start_hour = $6,
+            session_version = $7, pending_email = NULLIF($8, ''), totp_secret = NULLIF($9, ''),
+            has_password = $10
+        WHERE id = $11
         RETURNING id` // RETURNING helps confirm the update happened
 
 	args := []any{
@@ -216,10 +298,16 @@ func (m *UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash, // Assumes hash is updated if password was changed via user.Password.Set()
 		user.Active,
+		user.Timezone,
+		user.DayStartHour,
+		user.SessionVersion,
+		user.PendingEmail,
+		user.TOTPSecret,
+		user.HasPassword,
 		user.ID,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.Timeout)
 	defer cancel()
 
 	var returnedID int64 // Variable to scan the returned ID into
@@ -246,3 +334,56 @@ func (m *UserModel) Update(user *User) error {
 
 	return nil
 }
+
+// UseTOTPStep records that a two-factor code from the given time step has been
+// accepted. It reports false if a code from that step or a later one was
+// already used, so an intercepted code can't be replayed.
+func (m *UserModel) UseTOTPStep(id, step int64) (bool, error) {
+	query := `
+        UPDATE users
+        SET totp_last_step = $1
+        WHERE id = $2 AND totp_last_step < $1`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, step, id)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// Delete removes the user. Their habits, entries and tokens are removed with
+// them by the ON DELETE CASCADE foreign keys.
+func (m *UserModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+        DELETE FROM users
+        WHERE id = $1`
+
+	ctx, cancel := queryContext(m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/migrate"
+	"github.com/amari03/habit-tracker/internal/validator"
+	"github.com/amari03/habit-tracker/migrations"
+	_ "github.com/lib/pq"
+)
+
+// testDB opens the database in TRACKER_TEST_DB_DSN and brings its schema up to
+// date, skipping the test if it isn't set. Don't point it at a database
+// whose data matters.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TRACKER_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TRACKER_TEST_DB_DSN isn't set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	m, err := migrate.New(db, migrations.Files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.Up(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestUseTOTPStep(t *testing.T) {
+	db := testDB(t)
+	users := UserModel{DB: db, Timeout: DefaultTimeout}
+
+	// Left over if an earlier run was interrupted
+	if old, err := users.GetByEmail("totp-test@example.com"); err == nil {
+		users.Delete(old.ID)
+	}
+
+	user := &User{Name: "TOTP Test", Email: "totp-test@example.com", Active: true, Timezone: "UTC"}
+	err := user.Password.Set("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { users.Delete(user.ID) })
+
+	tests := []struct {
+		name string
+		step int64
+		want bool
+	}{
+		{"first code", 100, true},
+		{"same code again", 100, false},
+		{"earlier code", 99, false},
+		{"next code", 101, true},
+	}
+
+	for _, tt := range tests {
+		ok, err := users.UseTOTPStep(user.ID, tt.step)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ok != tt.want {
+			t.Errorf("%s: UseTOTPStep(%d) = %v, want %v", tt.name, tt.step, ok, tt.want)
+		}
+	}
+}
+
+func TestValidatePreferences(t *testing.T) {
+	tests := []struct {
+		timezone     string
+		dayStartHour int
+		valid        bool
+	}{
+		{"America/Belize", 0, true},
+		{"UTC", 6, true},
+		{"", 0, false},
+		{"Local", 0, false},
+		{"Mars/Olympus_Mons", 0, false},
+		{"UTC", 7, false},
+		{"UTC", -1, false},
+	}
+
+	for _, tt := range tests {
+		v := validator.NewValidator()
+		ValidatePreferences(v, &User{Timezone: tt.timezone, DayStartHour: tt.dayStartHour})
+		if v.ValidData() != tt.valid {
+			t.Errorf("ValidatePreferences(%q, %d) valid = %v, want %v", tt.timezone, tt.dayStartHour, v.ValidData(), tt.valid)
+		}
+	}
+}
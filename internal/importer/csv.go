@@ -0,0 +1,124 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+)
+
+// parseCSV reads a file in the shape written by this tracker's CSV export:
+// one row per entry with the habit's details repeated, and habits with no
+// entries on a row of their own with the entry columns blank. Rows are grouped
+// into habits by habit_id, or by title when habit_id is missing.
+func parseCSV(res *Result, r io.Reader) error {
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return ErrNoHabits
+		}
+		return err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\xef\xbb\xbf"))] = i
+	}
+	for _, required := range []string{"title", "frequency"} {
+		if _, ok := columns[required]; !ok {
+			return errors.New("the CSV file must have a " + required + " column")
+		}
+	}
+	get := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	index := make(map[string]int)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		key := get(record, "habit_id")
+		if key == "" {
+			key = get(record, "title")
+		}
+		i, ok := index[key]
+		if !ok {
+			habit, err := csvHabit(record, get)
+			if err != nil {
+				res.warn("Skipped line %d: %s.", line, err)
+				continue
+			}
+			i = len(res.Habits)
+			index[key] = i
+			res.Habits = append(res.Habits, data.ImportedHabit{Habit: habit})
+		}
+
+		if get(record, "entry_date") == "" {
+			continue
+		}
+		entry := data.HabitEntry{
+			Status: get(record, "status"),
+			Notes:  get(record, "notes"),
+		}
+		entry.EntryDate, err = time.Parse("2006-01-02", get(record, "entry_date"))
+		if err != nil {
+			res.warn("Skipped line %d: entry_date must be a date in the form YYYY-MM-DD.", line)
+			continue
+		}
+		if value := get(record, "value"); value != "" {
+			entry.Value, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				res.warn("Skipped line %d: value must be a number.", line)
+				continue
+			}
+		}
+		res.Habits[i].Entries = append(res.Habits[i].Entries, entry)
+	}
+	return nil
+}
+
+// csvHabit reads the habit columns of a row
+func csvHabit(record []string, get func([]string, string) string) (data.Habit, error) {
+	h := data.Habit{
+		Title:       get(record, "title"),
+		Description: get(record, "description"),
+		Frequency:   get(record, "frequency"),
+		Goal:        get(record, "goal"),
+		Unit:        get(record, "unit"),
+	}
+
+	var err error
+	if value := get(record, "times_per_week"); value != "" {
+		h.TimesPerWeek, err = strconv.Atoi(value)
+		if err != nil {
+			return h, errors.New("times_per_week must be a whole number")
+		}
+	}
+	if value := get(record, "weekdays"); value != "" {
+		h.Weekdays, err = data.ParseWeekdays(strings.Split(value, ","))
+		if err != nil {
+			return h, errors.New("weekdays contains an unknown day")
+		}
+	}
+	if value := get(record, "target_value"); value != "" {
+		h.TargetValue, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return h, errors.New("target_value must be a number")
+		}
+	}
+	return h, nil
+}
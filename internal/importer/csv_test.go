@@ -0,0 +1,58 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+)
+
+func TestParseCSV(t *testing.T) {
+	content := strings.Join([]string{
+		"habit_id,title,description,frequency,goal,times_per_week,weekdays,target_value,unit,entry_date,status,value,notes",
+		"1,Run,Morning run,custom,,,\"mon,thu\",5,km,2026-10-13,partial,3.5,felt slow",
+		"1,Run,Morning run,custom,,,\"mon,thu\",5,km,2026-10-12,completed,5,",
+		"2,Stretch,Ten minutes,daily,Stay loose,,,,,,,,",
+		"1,Run,Morning run,custom,,,\"mon,thu\",5,km,12/10/2026,completed,5,",
+		"1,Run,Morning run,custom,,,\"mon,thu\",5,km,2026-10-16,completed,lots,",
+		"3,Swim,Laps,custom,,many,,,,,,,",
+	}, "\n")
+
+	res := parse(t, FormatAuto, content)
+	if res.Format != FormatCSV {
+		t.Errorf("Format = %q, want %q", res.Format, FormatCSV)
+	}
+	if len(res.Habits) != 2 {
+		t.Fatalf("got %d habits, want 2", len(res.Habits))
+	}
+
+	run := res.Habits[0]
+	if run.Habit.Title != "Run" || run.Habit.Weekdays != data.NewWeekdays(time.Monday, time.Thursday) || run.Habit.TargetValue != 5 || run.Habit.Unit != "km" {
+		t.Errorf("Run = %+v", run.Habit)
+	}
+	if got, want := entriesOf(run), "2026-10-12=completed 2026-10-13=partial"; got != want {
+		t.Errorf("Run entries = %s, want %s", got, want)
+	}
+	if run.Entries[1].Value != 3.5 || run.Entries[1].Notes != "felt slow" {
+		t.Errorf("Run entry = %+v, want the value and notes kept", run.Entries[1])
+	}
+
+	stretch := res.Habits[1]
+	if stretch.Habit.Goal != "Stay loose" || len(stretch.Entries) != 0 {
+		t.Errorf("Stretch = %+v with %d entries, want its goal and no entries", stretch.Habit, len(stretch.Entries))
+	}
+
+	// The bad date, the bad value and the bad times_per_week
+	if len(res.Warnings) != 3 {
+		t.Errorf("warnings = %q, want 3", res.Warnings)
+	}
+}
+
+func TestParseCSVMissingColumn(t *testing.T) {
+	content := "habit_id,title\n1,Run\n"
+	_, err := Parse(FormatCSV, strings.NewReader(content), int64(len(content)), Options{})
+	if err == nil || !strings.Contains(err.Error(), "frequency column") {
+		t.Errorf("err = %v, want one about the frequency column", err)
+	}
+}
@@ -0,0 +1,170 @@
+package importer
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+)
+
+// habiticaExport is the part of Habitica's "user data" JSON export that holds
+// tasks. The API returns the same task lists at the top level, so both are accepted.
+type habiticaExport struct {
+	Tasks struct {
+		Dailys []habiticaTask `json:"dailys"`
+		Habits []habiticaTask `json:"habits"`
+	} `json:"tasks"`
+	Dailys []habiticaTask `json:"dailys"`
+	Habits []habiticaTask `json:"habits"`
+}
+
+type habiticaTask struct {
+	Text      string          `json:"text"`
+	Notes     string          `json:"notes"`
+	Frequency string          `json:"frequency"` // dailies: daily, weekly, monthly or yearly
+	EveryX    int             `json:"everyX"`
+	Repeat    map[string]bool `json:"repeat"` // dailies: su, m, t, w, th, f, s
+	Up        bool            `json:"up"`     // habits: has a positive button
+	History   []struct {
+		Date       habiticaTime `json:"date"`
+		Completed  *bool        `json:"completed"`
+		IsDue      *bool        `json:"isDue"`
+		ScoredUp   int          `json:"scoredUp"`
+		ScoredDown int          `json:"scoredDown"`
+	} `json:"history"`
+}
+
+// habiticaTime accepts both the millisecond timestamps and the ISO 8601
+// strings that different versions of Habitica write into task history.
+type habiticaTime struct {
+	time.Time
+}
+
+func (t *habiticaTime) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		err := json.Unmarshal(b, &s)
+		if err != nil {
+			return err
+		}
+		if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+			t.Time = time.UnixMilli(ms)
+			return nil
+		}
+		t.Time, err = time.Parse(time.RFC3339, s)
+		return err
+	}
+
+	var ms float64
+	err := json.Unmarshal(b, &ms)
+	if err != nil {
+		return err
+	}
+	t.Time = time.UnixMilli(int64(ms))
+	return nil
+}
+
+// habiticaDays maps Habitica's repeat keys onto weekdays.
+var habiticaDays = map[string]time.Weekday{
+	"su": time.Sunday, "m": time.Monday, "t": time.Tuesday, "w": time.Wednesday,
+	"th": time.Thursday, "f": time.Friday, "s": time.Saturday,
+}
+
+// parseHabitica reads Habitica's JSON user data export. Dailies become daily,
+// weekly or custom habits; their history records whether each due day was
+// completed. Positive habits become daily habits completed on every day they
+// were scored up. To-dos, rewards and negative-only habits are left out.
+func parseHabitica(res *Result, r io.Reader, opts Options) error {
+	var export habiticaExport
+	err := json.NewDecoder(r).Decode(&export)
+	if err != nil {
+		return err
+	}
+
+	// Concat copies, where append could write into the first list's spare capacity
+	dailies := slices.Concat(export.Tasks.Dailys, export.Dailys)
+	habits := slices.Concat(export.Tasks.Habits, export.Habits)
+
+	for _, task := range dailies {
+		h := data.ImportedHabit{Habit: newHabiticaDaily(res, task)}
+		for _, point := range task.History {
+			entry := data.HabitEntry{EntryDate: dateOf(point.Date.Time, opts.Location)}
+			switch {
+			case point.Completed != nil && *point.Completed:
+				entry.Status = "completed"
+			case point.Completed != nil && point.IsDue != nil && *point.IsDue:
+				entry.Status = "missed"
+			default:
+				continue // older history only records the task's value
+			}
+			h.Entries = append(h.Entries, entry)
+		}
+		res.Habits = append(res.Habits, h)
+	}
+
+	for _, task := range habits {
+		if !task.Up {
+			res.warn("Skipped habit %q: only positive habits can be imported.", task.Text)
+			continue
+		}
+		h := data.ImportedHabit{Habit: data.Habit{
+			Title:       strings.TrimSpace(task.Text),
+			Description: habiticaDescription(task),
+			Frequency:   "daily",
+		}}
+		for _, point := range task.History {
+			if point.ScoredUp > 0 {
+				h.Entries = append(h.Entries, data.HabitEntry{
+					EntryDate: dateOf(point.Date.Time, opts.Location),
+					Status:    "completed",
+				})
+			}
+		}
+		res.Habits = append(res.Habits, h)
+	}
+	return nil
+}
+
+// newHabiticaDaily maps a daily's repeat settings onto our frequencies.
+func newHabiticaDaily(res *Result, task habiticaTask) data.Habit {
+	h := data.Habit{
+		Title:       strings.TrimSpace(task.Text),
+		Description: habiticaDescription(task),
+		Frequency:   "daily",
+	}
+
+	switch task.Frequency {
+	case "", "daily":
+		if task.EveryX > 1 {
+			h.Frequency = "custom"
+			h.TimesPerWeek = max(7/task.EveryX, 1)
+			h.Goal = "Every " + strconv.Itoa(task.EveryX) + " days"
+		}
+	case "weekly":
+		var days []time.Weekday
+		for key, on := range task.Repeat {
+			if d, ok := habiticaDays[key]; ok && on {
+				days = append(days, d)
+			}
+		}
+		if len(days) > 0 && len(days) < 7 {
+			h.Frequency = "custom"
+			h.Weekdays = data.NewWeekdays(days...)
+		}
+	default:
+		res.warn("Habit %q repeats %s in Habitica; it was imported as a weekly habit.", h.Title, task.Frequency)
+		h.Frequency = "weekly"
+	}
+	return h
+}
+
+func habiticaDescription(task habiticaTask) string {
+	if notes := strings.TrimSpace(task.Notes); notes != "" {
+		return notes
+	}
+	return "Imported from Habitica"
+}
@@ -0,0 +1,88 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+)
+
+func TestParseHabitica(t *testing.T) {
+	ms := func(s string) int64 { return date(s).Add(8 * time.Hour).UnixMilli() }
+
+	// Tasks under "tasks" as in the user data export, and at the top level as
+	// the API returns them; both are read.
+	content := fmt.Sprintf(`{
+		"tasks": {
+			"dailys": [
+				{"text": "Meditate", "frequency": "weekly", "everyX": 1,
+				 "repeat": {"su": false, "m": true, "t": false, "w": true, "th": false, "f": true, "s": false},
+				 "history": [
+					{"date": %d, "isDue": true, "completed": true},
+					{"date": "2026-10-16T08:00:00.000Z", "isDue": true, "completed": false},
+					{"date": "%d", "value": 2}
+				 ]},
+				{"text": "Water plants", "frequency": "daily", "everyX": 3}
+			],
+			"habits": [
+				{"text": "Floss", "notes": "Every night", "up": true,
+				 "history": [{"date": %d, "scoredUp": 1, "scoredDown": 0}, {"date": %d, "scoredUp": 0, "scoredDown": 1}]},
+				{"text": "Junk food", "up": false}
+			]
+		},
+		"dailys": [
+			{"text": "Pay rent", "frequency": "monthly"}
+		]
+	}`, ms("2026-10-14"), ms("2026-10-13"), ms("2026-10-18"), ms("2026-10-17"))
+
+	res := parse(t, FormatAuto, content)
+	if res.Format != FormatHabitica {
+		t.Errorf("Format = %q, want %q", res.Format, FormatHabitica)
+	}
+
+	byTitle := make(map[string]data.ImportedHabit)
+	for _, h := range res.Habits {
+		byTitle[h.Habit.Title] = h
+	}
+	if len(byTitle) != 4 {
+		t.Fatalf("got habits %v, want Meditate, Water plants, Pay rent and Floss", byTitle)
+	}
+
+	meditate := byTitle["Meditate"]
+	if meditate.Habit.Frequency != "custom" || meditate.Habit.Weekdays != data.NewWeekdays(time.Monday, time.Wednesday, time.Friday) {
+		t.Errorf("Meditate = %+v, want custom on Monday, Wednesday and Friday", meditate.Habit)
+	}
+	if got, want := entriesOf(meditate), "2026-10-14=completed 2026-10-16=missed"; got != want {
+		t.Errorf("Meditate entries = %s, want %s", got, want)
+	}
+
+	if h := byTitle["Water plants"].Habit; h.Frequency != "custom" || h.TimesPerWeek != 2 {
+		t.Errorf("Water plants = %+v, want custom twice a week", h)
+	}
+	if h := byTitle["Pay rent"].Habit; h.Frequency != "weekly" {
+		t.Errorf("Pay rent = %+v, want weekly", h)
+	}
+
+	floss := byTitle["Floss"]
+	if floss.Habit.Frequency != "daily" || floss.Habit.Description != "Every night" {
+		t.Errorf("Floss = %+v", floss.Habit)
+	}
+	if got, want := entriesOf(floss), "2026-10-18=completed"; got != want {
+		t.Errorf("Floss entries = %s, want %s", got, want)
+	}
+
+	// Junk food is negative only and Pay rent repeats monthly
+	if len(res.Warnings) != 2 {
+		t.Errorf("warnings = %q, want 2", res.Warnings)
+	}
+}
+
+func TestParseHabiticaInvalid(t *testing.T) {
+	content := `{"tasks": {"dailys": [`
+	_, err := Parse(FormatHabitica, strings.NewReader(content), int64(len(content)), Options{})
+	if err == nil {
+		t.Error("Parse accepted truncated JSON")
+	}
+}
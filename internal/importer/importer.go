@@ -0,0 +1,178 @@
+// Package importer reads habit history exported from other trackers (Loop
+// Habit Tracker, Habitica) and from this tracker's own CSV export, and maps it
+// onto data.Habit and data.HabitEntry values ready to be saved.
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+	"github.com/amari03/habit-tracker/internal/validator"
+)
+
+// Supported formats. FormatAuto picks one by looking at the file.
+const (
+	FormatAuto     = "auto"
+	FormatLoop     = "loop"
+	FormatHabitica = "habitica"
+	FormatCSV      = "csv"
+)
+
+// PermittedFormats lists the formats that can be chosen on the import form.
+var PermittedFormats = []string{FormatAuto, FormatLoop, FormatHabitica, FormatCSV}
+
+var (
+	ErrUnknownFormat = errors.New("unrecognised file format")
+	ErrNoHabits      = errors.New("no habits found in the file")
+)
+
+// Options control how imported history is mapped onto this tracker.
+type Options struct {
+	Location  *time.Location // time zone for exports that record timestamps rather than dates
+	WeekStart time.Weekday   // weekly habits are logged against the start of the week
+}
+
+// Result is a parsed export. Habits and entries that couldn't be mapped are
+// left out and described in Warnings.
+type Result struct {
+	Format   string
+	Habits   []data.ImportedHabit
+	Warnings []string
+}
+
+func (res *Result) warn(format string, args ...any) {
+	res.Warnings = append(res.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Parse reads an export in the given format. The whole file is needed up
+// front because Loop exports are zip archives.
+func Parse(format string, file io.ReaderAt, size int64, opts Options) (*Result, error) {
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+
+	if format == FormatAuto {
+		var err error
+		format, err = detect(file, size)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := &Result{Format: format}
+	var err error
+	switch format {
+	case FormatLoop:
+		err = parseLoop(res, file, size)
+	case FormatHabitica:
+		err = parseHabitica(res, io.NewSectionReader(file, 0, size), opts)
+	case FormatCSV:
+		err = parseCSV(res, io.NewSectionReader(file, 0, size))
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res.normalize(opts)
+	if len(res.Habits) == 0 {
+		return nil, ErrNoHabits
+	}
+	return res, nil
+}
+
+// detect guesses the format from the start of the file: Loop exports are zip
+// archives (or its bare Checkmarks.csv), Habitica exports are JSON, and our own
+// export is a CSV starting with a habit_id column.
+func detect(file io.ReaderAt, size int64) (string, error) {
+	head := make([]byte, min(size, 512))
+	_, err := file.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")) // UTF-8 byte order mark
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return FormatLoop, nil
+	case bytes.HasPrefix(bytes.TrimSpace(head), []byte("{")):
+		return FormatHabitica, nil
+	case bytes.HasPrefix(head, []byte("habit_id,")):
+		return FormatCSV, nil
+	case bytes.HasPrefix(head, []byte("Date,")):
+		return FormatLoop, nil
+	default:
+		return "", ErrUnknownFormat
+	}
+}
+
+// statusRank decides which entry wins when several land on the same date.
+var statusRank = map[string]int{"missed": 1, "skipped": 2, "partial": 3, "completed": 4}
+
+// normalize validates the parsed habits and entries, keys weekly entries to
+// the start of their week, merges entries that fall on the same date and sorts
+// them oldest first.
+func (res *Result) normalize(opts Options) {
+	habits := res.Habits[:0]
+	for _, h := range res.Habits {
+		// Other trackers have no separate goal, so describe the schedule instead
+		if h.Habit.Goal == "" {
+			h.Habit.Goal = h.Habit.ScheduleSummary()
+		}
+
+		v := validator.NewValidator()
+		data.ValidateHabit(v, &h.Habit)
+		if !v.ValidData() {
+			field := firstKey(v.Errors)
+			res.warn("Skipped habit %q: %s %s.", h.Habit.Title, field, v.Errors[field])
+			continue
+		}
+
+		byDate := make(map[string]data.HabitEntry, len(h.Entries))
+		for _, entry := range h.Entries {
+			v := validator.NewValidator()
+			data.ValidateHabitEntry(v, &entry)
+			if !v.ValidData() {
+				res.warn("Skipped an entry of %q on %s: invalid %s.", h.Habit.Title, entry.EntryDate.Format("2006-01-02"), firstKey(v.Errors))
+				continue
+			}
+
+			entry.EntryDate = h.Habit.PeriodStart(entry.EntryDate, opts.WeekStart)
+			key := entry.EntryDate.Format("2006-01-02")
+			if existing, ok := byDate[key]; ok && statusRank[existing.Status] >= statusRank[entry.Status] {
+				continue
+			}
+			byDate[key] = entry
+		}
+
+		h.Entries = h.Entries[:0]
+		for _, entry := range byDate {
+			h.Entries = append(h.Entries, entry)
+		}
+		sort.Slice(h.Entries, func(i, j int) bool {
+			return h.Entries[i].EntryDate.Before(h.Entries[j].EntryDate)
+		})
+		habits = append(habits, h)
+	}
+	res.Habits = habits
+}
+
+func firstKey(m map[string]string) string {
+	for key := range m {
+		return key
+	}
+	return ""
+}
+
+// dateOf returns the calendar date of t in loc as midnight UTC, the form entry
+// dates are compared in.
+func dateOf(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,138 @@
+package importer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+)
+
+// parse runs Parse on content, failing the test on an error.
+func parse(t *testing.T, format, content string) *Result {
+	t.Helper()
+
+	res, err := Parse(format, strings.NewReader(content), int64(len(content)), Options{WeekStart: time.Monday})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return res
+}
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// entriesOf formats entries as date=status, oldest first, for comparing.
+func entriesOf(h data.ImportedHabit) string {
+	var s []string
+	for _, e := range h.Entries {
+		s = append(s, e.EntryDate.Format("2006-01-02")+"="+e.Status)
+	}
+	return strings.Join(s, " ")
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"zip archive", "PK\x03\x04rest of the archive", FormatLoop},
+		{"Loop checkmarks", "Date,Gym,\n2026-10-18,2,\n", FormatLoop},
+		{"Habitica JSON", "  \n{\"tasks\": {}}", FormatHabitica},
+		{"our CSV", "habit_id,title,frequency\n", FormatCSV},
+		{"our CSV with a byte order mark", "\xef\xbb\xbfhabit_id,title,frequency\n", FormatCSV},
+	}
+
+	for _, tt := range tests {
+		got, err := detect(strings.NewReader(tt.content), int64(len(tt.content)))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: detect = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	_, err := detect(strings.NewReader("name,when\n"), 10)
+	if !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("detect of an unknown file: err = %v, want ErrUnknownFormat", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		content string
+		want    error
+	}{
+		{"unknown format", "xml", "<habits/>", ErrUnknownFormat},
+		{"undetectable", FormatAuto, "name,when\n", ErrUnknownFormat},
+		{"header only", FormatCSV, "habit_id,title,frequency\n", ErrNoHabits},
+		{"every habit invalid", FormatCSV, "habit_id,title,frequency\n1,,daily\n", ErrNoHabits},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.format, strings.NewReader(tt.content), int64(len(tt.content)), Options{})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	res := &Result{Habits: []data.ImportedHabit{
+		{
+			Habit: data.Habit{Title: "Read", Description: "Books", Frequency: "daily"},
+			Entries: []data.HabitEntry{
+				{EntryDate: date("2026-10-14"), Status: "completed"},
+				{EntryDate: date("2026-10-12"), Status: "missed"},
+				{EntryDate: date("2026-10-12"), Status: "completed"}, // beats missed
+				{EntryDate: date("2026-10-13"), Status: "partial"},
+				{EntryDate: date("2026-10-13"), Status: "skipped"}, // loses to partial
+				{EntryDate: date("2026-10-15"), Status: "done"},    // invalid status
+			},
+		},
+		{
+			Habit: data.Habit{Title: "Review", Description: "Plan the week", Frequency: "weekly"},
+			Entries: []data.HabitEntry{
+				{EntryDate: date("2026-10-15"), Status: "missed"},    // Thursday
+				{EntryDate: date("2026-10-13"), Status: "completed"}, // Tuesday, same week
+				{EntryDate: date("2026-10-05"), Status: "skipped"},   // Monday, week before
+			},
+		},
+		{
+			Habit: data.Habit{Title: "", Description: "No title", Frequency: "daily"},
+		},
+	}}
+
+	res.normalize(Options{WeekStart: time.Monday})
+
+	if len(res.Habits) != 2 {
+		t.Fatalf("got %d habits, want 2 (the untitled one skipped)", len(res.Habits))
+	}
+
+	if got, want := entriesOf(res.Habits[0]), "2026-10-12=completed 2026-10-13=partial 2026-10-14=completed"; got != want {
+		t.Errorf("daily entries = %s, want %s", got, want)
+	}
+	if got, want := entriesOf(res.Habits[1]), "2026-10-05=skipped 2026-10-12=completed"; got != want {
+		t.Errorf("weekly entries = %s, want %s", got, want)
+	}
+	for _, h := range res.Habits {
+		if h.Habit.Goal == "" {
+			t.Errorf("%q has no goal, want the schedule summary", h.Habit.Title)
+		}
+	}
+
+	if len(res.Warnings) != 2 {
+		t.Errorf("warnings = %q, want one for the invalid entry and one for the untitled habit", res.Warnings)
+	}
+}
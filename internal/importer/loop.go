@@ -0,0 +1,247 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amari03/habit-tracker/internal/data"
+)
+
+// Checkmark values written by Loop Habit Tracker. YES_AUTO marks days that
+// were implied by the habit's frequency rather than checked, so they aren't
+// imported; neither are NO and UNKNOWN days.
+const (
+	loopYesAuto   = 1
+	loopYesManual = 2
+	loopSkip      = 3
+)
+
+// loopHabit is a row of Loop's Habits.csv.
+type loopHabit struct {
+	name        string
+	description string
+	numerator   int
+	denominator int
+	numerical   bool
+	unit        string
+	target      float64
+}
+
+// parseLoop reads a Loop Habit Tracker export: either the zip archive made by
+// "Export as CSV", or just the Checkmarks.csv from inside it. Habits.csv, when
+// present, supplies descriptions, frequencies and targets; without it every
+// habit is imported as a daily habit.
+func parseLoop(res *Result, file io.ReaderAt, size int64) error {
+	var habitsCSV, checkmarksCSV io.ReadCloser
+
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		if !errors.Is(err, zip.ErrFormat) {
+			return err
+		}
+		checkmarksCSV = io.NopCloser(io.NewSectionReader(file, 0, size))
+	} else {
+		for _, f := range archive.File {
+			// The archive also holds a folder per habit; only the top-level files are needed
+			if path.Dir(f.Name) != "." {
+				continue
+			}
+			switch path.Base(f.Name) {
+			case "Habits.csv":
+				habitsCSV, err = f.Open()
+			case "Checkmarks.csv":
+				checkmarksCSV, err = f.Open()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if checkmarksCSV == nil {
+			return errors.New("the archive doesn't contain a Checkmarks.csv file")
+		}
+	}
+	defer checkmarksCSV.Close()
+
+	habits := make(map[string]loopHabit)
+	if habitsCSV != nil {
+		defer habitsCSV.Close()
+		habits, err = readLoopHabits(habitsCSV)
+		if err != nil {
+			return err
+		}
+	}
+
+	return readLoopCheckmarks(res, checkmarksCSV, habits)
+}
+
+// readLoopHabits reads Habits.csv by column name, accepting the headers of
+// both older ("NumRepetitions", "Interval") and newer ("FrequencyNumerator",
+// "FrequencyDenominator", "Type", "Unit", "Target Value") versions of Loop.
+func readLoopHabits(r io.Reader) (map[string]loopHabit, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	habits := make(map[string]loopHabit)
+	if len(records) == 0 {
+		return habits, nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	get := func(record []string, names ...string) string {
+		for _, name := range names {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+		}
+		return ""
+	}
+
+	for _, record := range records[1:] {
+		h := loopHabit{
+			name:        get(record, "Name"),
+			description: get(record, "Description"),
+			unit:        get(record, "Unit"),
+			numerical:   get(record, "Type") == "1",
+		}
+		if h.description == "" {
+			h.description = get(record, "Question")
+		}
+		h.numerator, _ = strconv.Atoi(get(record, "FrequencyNumerator", "NumRepetitions"))
+		h.denominator, _ = strconv.Atoi(get(record, "FrequencyDenominator", "Interval"))
+		h.target, _ = strconv.ParseFloat(get(record, "Target Value", "TargetValue"), 64)
+		habits[h.name] = h
+	}
+	return habits, nil
+}
+
+// readLoopCheckmarks reads Checkmarks.csv, which has a Date column followed by
+// one column per habit and one row per day. Numerical habits store their
+// amount multiplied by 1000.
+func readLoopCheckmarks(res *Result, r io.Reader, habits map[string]loopHabit) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 // Loop ends every line with a trailing comma
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return ErrNoHabits
+		}
+		return err
+	}
+	if len(header) == 0 || strings.TrimPrefix(header[0], "\xef\xbb\xbf") != "Date" {
+		return errors.New("Checkmarks.csv must start with a Date column")
+	}
+
+	var imported []*data.ImportedHabit
+	var meta []loopHabit
+	for _, name := range header[1:] {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			imported = append(imported, nil)
+			meta = append(meta, loopHabit{})
+			continue
+		}
+		lh, ok := habits[name]
+		if !ok {
+			lh = loopHabit{name: name, numerator: 1, denominator: 1}
+		}
+		imported = append(imported, &data.ImportedHabit{Habit: newLoopHabit(lh)})
+		meta = append(meta, lh)
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		date, err := time.Parse("2006-01-02", strings.TrimSpace(record[0]))
+		if err != nil {
+			res.warn("Skipped a row with an invalid date %q.", record[0])
+			continue
+		}
+
+		for i, field := range record[1:] {
+			if i >= len(imported) || imported[i] == nil {
+				break
+			}
+			value, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				continue
+			}
+
+			h := imported[i]
+			entry := data.HabitEntry{EntryDate: date}
+			if meta[i].numerical {
+				if value <= 0 {
+					continue
+				}
+				entry.Value = math.Round(value) / 1000
+				entry.Status = "partial"
+				if h.Habit.Completion(entry.Value) >= 1 {
+					entry.Status = "completed"
+				}
+			} else {
+				switch int(value) {
+				case loopYesManual:
+					entry.Status = "completed"
+				case loopSkip:
+					entry.Status = "skipped"
+				default:
+					continue
+				}
+			}
+			h.Entries = append(h.Entries, entry)
+		}
+	}
+
+	for _, h := range imported {
+		if h != nil {
+			res.Habits = append(res.Habits, *h)
+		}
+	}
+	return nil
+}
+
+// newLoopHabit maps a Loop habit's "N times every M days" frequency onto ours.
+func newLoopHabit(lh loopHabit) data.Habit {
+	h := data.Habit{
+		Title:       lh.name,
+		Description: lh.description,
+		Frequency:   "daily",
+	}
+	if h.Description == "" {
+		h.Description = "Imported from Loop Habit Tracker"
+	}
+	if lh.numerical && lh.target > 0 {
+		h.TargetValue = lh.target
+		h.Unit = lh.unit
+	}
+
+	switch {
+	case lh.denominator <= 1 || lh.numerator >= lh.denominator:
+		// every day
+	case lh.denominator == 7 && lh.numerator == 1:
+		h.Frequency = "weekly"
+	default:
+		h.Frequency = "custom"
+		timesPerWeek := int(math.Round(float64(lh.numerator) * 7 / float64(lh.denominator)))
+		h.TimesPerWeek = min(max(timesPerWeek, 1), 7)
+		h.Goal = strconv.Itoa(lh.numerator) + " times every " + strconv.Itoa(lh.denominator) + " days"
+	}
+	return h
+}
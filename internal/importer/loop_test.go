@@ -0,0 +1,119 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+const loopCheckmarks = `Date,Wake up early,Gym,Water,
+2026-10-18,2,3,1500,
+2026-10-17,0,2,2000,
+2026-10-16,1,-1,0,
+not a date,2,2,2000,
+`
+
+func TestParseLoopCheckmarks(t *testing.T) {
+	res := parse(t, FormatAuto, loopCheckmarks)
+	if res.Format != FormatLoop {
+		t.Errorf("Format = %q, want %q", res.Format, FormatLoop)
+	}
+	if len(res.Habits) != 3 {
+		t.Fatalf("got %d habits, want 3", len(res.Habits))
+	}
+
+	// Without Habits.csv every habit is a yes/no daily habit
+	tests := []struct {
+		title   string
+		entries string
+	}{
+		{"Wake up early", "2026-10-18=completed"},          // YES_AUTO and NO aren't imported
+		{"Gym", "2026-10-17=completed 2026-10-18=skipped"}, // neither is UNKNOWN
+		{"Water", ""},
+	}
+	for i, tt := range tests {
+		h := res.Habits[i]
+		if h.Habit.Title != tt.title || h.Habit.Frequency != "daily" {
+			t.Errorf("habit %d = %q %s, want %q daily", i, h.Habit.Title, h.Habit.Frequency, tt.title)
+		}
+		if got := entriesOf(h); got != tt.entries {
+			t.Errorf("%s entries = %s, want %s", tt.title, got, tt.entries)
+		}
+	}
+
+	if len(res.Warnings) != 1 {
+		t.Errorf("warnings = %q, want one for the invalid date", res.Warnings)
+	}
+}
+
+func TestParseLoopArchive(t *testing.T) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"Habits.csv": "Position,Name,Type,Question,Description,FrequencyNumerator,FrequencyDenominator,Color,Unit,Target Type,Target Value,Archived?\n" +
+			"001,Wake up early,0,,Before seven,1,1,#000,,0,0,false\n" +
+			"002,Gym,0,Did you go?,,3,7,#000,,0,0,false\n" +
+			"003,Water,1,,,1,1,#000,L,0,2,false\n",
+		"Checkmarks.csv":         loopCheckmarks,
+		"001 Gym/Checkmarks.csv": "Date,Value\n", // per-habit folders are ignored
+	} {
+		f, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	err := archive.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := parse(t, FormatAuto, buf.String())
+	if len(res.Habits) != 3 {
+		t.Fatalf("got %d habits, want 3", len(res.Habits))
+	}
+
+	wake, gym, water := res.Habits[0].Habit, res.Habits[1].Habit, res.Habits[2].Habit
+	if wake.Description != "Before seven" || wake.Frequency != "daily" {
+		t.Errorf("Wake up early = %+v", wake)
+	}
+	if gym.Description != "Did you go?" || gym.Frequency != "custom" || gym.TimesPerWeek != 3 {
+		t.Errorf("Gym = %+v, want the question as description and 3 times a week", gym)
+	}
+	if water.TargetValue != 2 || water.Unit != "L" {
+		t.Errorf("Water = %+v, want a 2 L target", water)
+	}
+
+	// Amounts are stored times 1000; reaching the target completes the day
+	want := "2026-10-17=completed 2026-10-18=partial"
+	if got := entriesOf(res.Habits[2]); got != want {
+		t.Errorf("Water entries = %s, want %s", got, want)
+	}
+	if res.Habits[2].Entries[1].Value != 1.5 {
+		t.Errorf("Water value = %v, want 1.5", res.Habits[2].Entries[1].Value)
+	}
+}
+
+func TestNewLoopHabit(t *testing.T) {
+	tests := []struct {
+		numerator, denominator int
+		frequency              string
+		timesPerWeek           int
+	}{
+		{1, 1, "daily", 0},
+		{0, 0, "daily", 0},
+		{2, 2, "daily", 0},
+		{1, 7, "weekly", 0},
+		{3, 7, "custom", 3},
+		{1, 2, "custom", 4}, // 3.5 rounds up
+		{1, 30, "custom", 1},
+	}
+
+	for _, tt := range tests {
+		h := newLoopHabit(loopHabit{name: "Habit", numerator: tt.numerator, denominator: tt.denominator})
+		if h.Frequency != tt.frequency || h.TimesPerWeek != tt.timesPerWeek {
+			t.Errorf("%d every %d days = %s %d times a week, want %s %d",
+				tt.numerator, tt.denominator, h.Frequency, h.TimesPerWeek, tt.frequency, tt.timesPerWeek)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Package lifecycle runs an app's background workers and stops them together
+// when the app shuts down. Long-running workers, like a periodic cleanup,
+// watch the context they're given and return when it's done; one-off tasks,
+// like sending an email, are simply waited for.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Manager keeps track of running workers.
+type Manager struct {
+	logger *slog.Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	stopped bool
+}
+
+// New creates a Manager that logs workers' errors and panics to logger.
+func New(logger *slog.Logger) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{logger: logger, ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in a goroutine. ctx is cancelled when the Manager shuts down, and
+// Shutdown waits for fn to return. An error or panic is logged rather than
+// crashing the app. Once shutdown has started, fn isn't run.
+func (m *Manager) Go(name string, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		m.logger.Error("background worker started after shutdown", "worker", name)
+		return
+	}
+	m.wg.Add(1)
+
+	go func() {
+		defer m.wg.Done()
+		m.run(m.ctx, name, fn)
+	}()
+}
+
+// run calls fn, logging its error or panic.
+func (m *Manager) run(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error(fmt.Sprintf("%v", err), "worker", name)
+		}
+	}()
+
+	err := fn(ctx)
+	if err != nil {
+		m.logger.Error("background worker failed", "worker", name, "error", err)
+	}
+}
+
+// Every runs fn every interval until shutdown, starting one interval from
+// now. An error or panic is logged and fn runs again at the next interval.
+func (m *Manager) Every(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	m.Go(name, func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				m.run(ctx, name, fn)
+			}
+		}
+	})
+}
+
+// Shutdown tells the workers to stop and waits for them to return, or for ctx
+// to be done, whichever comes first.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.stopped = true
+	m.mu.Unlock()
+	m.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("lifecycle: background workers still running: %w", ctx.Err())
+	}
+}
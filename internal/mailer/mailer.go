@@ -0,0 +1,189 @@
+// Package mailer sends the application's emails. Each email is a template in
+// templates/ defining "subject", "plainBody" and "htmlBody". Mail goes out
+// over SMTP in production; for local runs LogMailer writes it to the log and,
+// optionally, to .eml files instead.
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer sends the email defined by templateFile to recipient, rendering it
+// with data.
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+// message is a rendered email.
+type message struct {
+	subject   string
+	plainBody string
+	htmlBody  string
+}
+
+func render(templateFile string, data any) (*message, error) {
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	// The HTML body is rendered separately so values are escaped
+	htmlTmpl, err := htmltemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &message{
+		subject:   subject.String(),
+		plainBody: plainBody.String(),
+		htmlBody:  htmlBody.String(),
+	}, nil
+}
+
+// build writes msg as a multipart/alternative MIME message.
+func build(sender, recipient string, msg *message) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	body := multipart.NewWriter(buf)
+
+	fmt.Fprintf(buf, "From: %s\r\n", sender)
+	fmt.Fprintf(buf, "To: %s\r\n", recipient)
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", body.Boundary())
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.plainBody},
+		{"text/html; charset=utf-8", msg.htmlBody},
+	} {
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write([]byte(part.content))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := body.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SMTPMailer sends email through an SMTP server, using STARTTLS when the
+// server offers it.
+type SMTPMailer struct {
+	addr     string
+	auth     smtp.Auth
+	sender   string // the From header, e.g. "Habit Tracker <no-reply@example.com>"
+	envelope string // the bare address for MAIL FROM
+}
+
+// NewSMTP creates an SMTPMailer. The username and password may be empty for
+// servers that don't need authentication. The sender may include a display
+// name.
+func NewSMTP(host string, port int, username, password, sender string) (*SMTPMailer, error) {
+	from, err := mail.ParseAddress(sender)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: invalid sender %q: %w", sender, err)
+	}
+
+	m := &SMTPMailer{
+		addr:     host + ":" + strconv.Itoa(port),
+		sender:   sender,
+		envelope: from.Address,
+	}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(recipient, templateFile string, data any) error {
+	msg, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	raw, err := build(m.sender, recipient, msg)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.addr, m.auth, m.envelope, []string{recipient}, raw)
+}
+
+// LogMailer doesn't send anything: it logs each email's recipient, subject
+// and plain text body, and when Dir is set also saves the full message there
+// as an .eml file that can be opened in a mail client.
+type LogMailer struct {
+	Logger *slog.Logger
+	Dir    string
+	Sender string
+}
+
+func (m *LogMailer) Send(recipient, templateFile string, data any) error {
+	msg, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.Logger.Info("email", "to", recipient, "subject", msg.subject, "body", msg.plainBody)
+
+	if m.Dir == "" {
+		return nil
+	}
+
+	raw, err := build(m.Sender, recipient, msg)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(m.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+	name := time.Now().Format("20060102-150405.000000000") + "-" + filepath.Base(templateFile) + ".eml"
+	return os.WriteFile(filepath.Join(m.Dir, name), raw, 0o644)
+}
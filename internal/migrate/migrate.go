@@ -0,0 +1,272 @@
+// Package migrate applies SQL migrations to a PostgreSQL database. It keeps
+// the schema version in the same schema_migrations table as the migrate CLI
+// (github.com/golang-migrate/migrate), so either can be used on a database.
+// Each migration runs in a transaction together with the version change.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// lockID is the PostgreSQL advisory lock held while migrating, so two
+// instances starting at once don't both apply the same migration
+const lockID = 7_294_024_461
+
+var fileRX = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// ErrDirty means a migration failed part way through, as only the migrate CLI
+// can leave it. The schema has to be fixed by hand before migrating again.
+var ErrDirty = errors.New("migrate: database is dirty; a migration failed part way, fix the schema and run `migrate force VERSION` with the migrate CLI")
+
+// Migration is a numbered schema change.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes how far the database schema is behind or ahead.
+type Status struct {
+	Current int64 // 0 if no migrations have been applied
+	Latest  int64
+	Dirty   bool
+	Pending []Migration
+	Unknown bool // Current isn't one of our migrations, e.g. a newer build ran
+}
+
+// Migrator applies migrations to a database.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration // in version order
+}
+
+// New loads the migrations in fsys.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: %s: %w", entry.Name(), err)
+		}
+		contents, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migrate: version %d is used by both %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migrate: version %d (%s) has no up migration", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Status reports the database's schema version and the migrations not yet applied.
+func (m *Migrator) Status(ctx context.Context) (*Status, error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	current, dirty, err := version(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	return m.status(current, dirty), nil
+}
+
+func (m *Migrator) status(current int64, dirty bool) *Status {
+	s := &Status{Current: current, Dirty: dirty, Unknown: current != 0}
+	for _, migration := range m.Migrations {
+		s.Latest = migration.Version
+		if migration.Version == current {
+			s.Unknown = false
+		}
+		if migration.Version > current {
+			s.Pending = append(s.Pending, migration)
+		}
+	}
+	return s
+}
+
+// Up applies up to n pending migrations, or all of them if n is zero or
+// less, and returns the ones applied.
+func (m *Migrator) Up(ctx context.Context, n int) ([]Migration, error) {
+	var applied []Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+
+		for _, migration := range m.status(current, dirty).Pending {
+			if n > 0 && len(applied) == n {
+				break
+			}
+			err = apply(ctx, conn, migration.Up, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migrate: %d_%s.up.sql: %w", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the last n applied migrations, or all of them if n is zero
+// or less, and returns the ones rolled back.
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	var rolledBack []Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+		if m.status(current, dirty).Unknown {
+			return fmt.Errorf("migrate: database is at version %d, which this build doesn't know how to roll back", current)
+		}
+
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			migration := m.Migrations[i]
+			if migration.Version > current {
+				continue
+			}
+			if n > 0 && len(rolledBack) == n {
+				break
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migrate: version %d (%s) has no down migration", migration.Version, migration.Name)
+			}
+
+			var previous int64
+			if i > 0 {
+				previous = m.Migrations[i-1].Version
+			}
+			err = apply(ctx, conn, migration.Down, previous)
+			if err != nil {
+				return fmt.Errorf("migrate: %d_%s.down.sql: %w", migration.Version, migration.Name, err)
+			}
+			rolledBack = append(rolledBack, migration)
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// locked runs fn on a single connection holding the migration lock.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+
+	return fn(conn)
+}
+
+// version returns the schema version, creating the table that records it
+// if this is a new database.
+func version(ctx context.Context, conn *sql.Conn) (int64, bool, error) {
+	_, err := conn.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version BIGINT NOT NULL PRIMARY KEY,
+            dirty BOOLEAN NOT NULL
+        )`)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var version int64
+	var dirty bool
+	err = conn.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// apply runs a migration's SQL and records the new version in one transaction.
+// Version 0 means no migrations are applied, which the migrate CLI records as
+// an empty table.
+func apply(ctx context.Context, conn *sql.Conn, query string, newVersion int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	if newVersion != 0 {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, newVersion)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
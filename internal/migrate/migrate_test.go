@@ -0,0 +1,368 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/amari03/habit-tracker/migrations"
+	_ "github.com/lib/pq"
+)
+
+// testSchema keeps these tests' tables apart from the ones other packages'
+// tests use in the same database, since packages are tested in parallel.
+const testSchema = "migrate_test"
+
+// testDB opens the database in TRACKER_TEST_DB_DSN with an empty schema of its
+// own first in the search path, skipping the test if it isn't set. Don't
+// point it at a database whose data matters.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TRACKER_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TRACKER_TEST_DB_DSN isn't set")
+	}
+
+	// Extensions such as citext live in public, so it stays in the path
+	if strings.Contains(dsn, "://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "search_path=" + testSchema + "%2Cpublic"
+	} else {
+		dsn += " search_path=" + testSchema + ",public"
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`DROP SCHEMA IF EXISTS ` + testSchema + ` CASCADE; CREATE SCHEMA ` + testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DROP SCHEMA IF EXISTS ` + testSchema + ` CASCADE`) })
+	return db
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// testMigrations creates a table each, so a version's tables show which
+// migrations are applied.
+var testMigrations = fstest.MapFS{
+	"000001_create_one.up.sql":     {Data: []byte(`CREATE TABLE one (id INT)`)},
+	"000001_create_one.down.sql":   {Data: []byte(`DROP TABLE one`)},
+	"000002_create_two.up.sql":     {Data: []byte(`CREATE TABLE two (id INT)`)},
+	"000002_create_two.down.sql":   {Data: []byte(`DROP TABLE two`)},
+	"000005_create_three.up.sql":   {Data: []byte(`CREATE TABLE three (id INT)`)},
+	"000005_create_three.down.sql": {Data: []byte(`DROP TABLE three`)},
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"000002_second.up.sql":    {Data: []byte("up 2")},
+		"000001_first.up.sql":     {Data: []byte("up 1")},
+		"000001_first.down.sql":   {Data: []byte("down 1")},
+		"README.md":               {Data: []byte("not a migration")},
+		"000003_sub.up.sql/x.sql": {Data: []byte("in a directory")},
+	}
+
+	got, err := load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "first", Up: "up 1", Down: "down 1"},
+		{Version: 2, Name: "second", Up: "up 2"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("load = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("load[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{
+			"duplicate version",
+			fstest.MapFS{
+				"000001_first.up.sql": {Data: []byte("up")},
+				"000001_other.up.sql": {Data: []byte("up")},
+			},
+			"version 1 is used by both",
+		},
+		{
+			"no up migration",
+			fstest.MapFS{
+				"000001_first.up.sql":    {Data: []byte("up")},
+				"000002_second.down.sql": {Data: []byte("down")},
+			},
+			"version 2 (second) has no up migration",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(tt.fsys)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("load error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	m, err := New(nil, migrations.Files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range m.Migrations {
+		if migration.Down == "" {
+			t.Errorf("version %d (%s) has no down migration", migration.Version, migration.Name)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	m, err := New(nil, testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		current int64
+		pending int
+		unknown bool
+	}{
+		{0, 3, false},
+		{1, 2, false},
+		{5, 0, false},
+		{3, 1, true}, // between known versions
+		{9, 0, true}, // newer than this build
+	}
+
+	for _, tt := range tests {
+		s := m.status(tt.current, false)
+		if s.Latest != 5 || len(s.Pending) != tt.pending || s.Unknown != tt.unknown {
+			t.Errorf("status(%d) = latest %d, %d pending, unknown %v; want latest 5, %d pending, unknown %v",
+				tt.current, s.Latest, len(s.Pending), s.Unknown, tt.pending, tt.unknown)
+		}
+	}
+}
+
+// checkVersion fails the test unless the database is at version want, with
+// exactly the tables of testMigrations up to it.
+func checkVersion(t *testing.T, m *Migrator, want int64) {
+	t.Helper()
+
+	s, err := m.Status(testContext(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Current != want || s.Dirty {
+		t.Errorf("version = %d (dirty %v), want %d", s.Current, s.Dirty, want)
+	}
+
+	var rows int
+	err = m.DB.QueryRow(`SELECT count(*) FROM schema_migrations`).Scan(&rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want == 0 && rows != 0 {
+		t.Errorf("schema_migrations has %d rows at version 0, want none", rows)
+	}
+
+	for _, table := range []struct {
+		name    string
+		version int64
+	}{{"one", 1}, {"two", 2}, {"three", 5}} {
+		var exists bool
+		err := m.DB.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, testSchema+"."+table.name).Scan(&exists)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != (table.version <= want) {
+			t.Errorf("at version %d table %s exists = %v", want, table.name, exists)
+		}
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	db := testDB(t)
+	ctx := testContext(t)
+	m, err := New(db, testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkVersion(t, m, 0)
+
+	applied, err := m.Up(ctx, 2)
+	if err != nil || len(applied) != 2 {
+		t.Fatalf("Up(2) = %d applied, %v; want 2", len(applied), err)
+	}
+	checkVersion(t, m, 2)
+
+	applied, err = m.Up(ctx, 0)
+	if err != nil || len(applied) != 1 {
+		t.Fatalf("Up(0) = %d applied, %v; want 1", len(applied), err)
+	}
+	checkVersion(t, m, 5)
+
+	applied, err = m.Up(ctx, 0)
+	if err != nil || len(applied) != 0 {
+		t.Fatalf("Up(0) when up to date = %d applied, %v; want none", len(applied), err)
+	}
+
+	rolledBack, err := m.Down(ctx, 1)
+	if err != nil || len(rolledBack) != 1 || rolledBack[0].Version != 5 {
+		t.Fatalf("Down(1) = %+v, %v; want version 5 rolled back", rolledBack, err)
+	}
+	checkVersion(t, m, 2)
+
+	rolledBack, err = m.Down(ctx, 0)
+	if err != nil || len(rolledBack) != 2 {
+		t.Fatalf("Down(0) = %d rolled back, %v; want 2", len(rolledBack), err)
+	}
+	checkVersion(t, m, 0)
+
+	rolledBack, err = m.Down(ctx, 0)
+	if err != nil || len(rolledBack) != 0 {
+		t.Fatalf("Down(0) at version 0 = %d rolled back, %v; want none", len(rolledBack), err)
+	}
+}
+
+func TestFailedMigration(t *testing.T) {
+	db := testDB(t)
+	ctx := testContext(t)
+
+	fsys := fstest.MapFS{
+		"000001_create_one.up.sql":   testMigrations["000001_create_one.up.sql"],
+		"000001_create_one.down.sql": testMigrations["000001_create_one.down.sql"],
+		"000002_broken.up.sql":       {Data: []byte(`CREATE TABLE two (id INT); SELECT no_such_column FROM one`)},
+		"000002_broken.down.sql":     {Data: []byte(`DROP TABLE two`)},
+	}
+	m, err := New(db, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := m.Up(ctx, 0)
+	if err == nil || !strings.Contains(err.Error(), "000002_broken.up.sql") {
+		t.Errorf("Up error = %v, want one naming the broken migration", err)
+	}
+	if len(applied) != 1 {
+		t.Errorf("Up applied %d, want 1", len(applied))
+	}
+
+	// The broken migration is rolled back with its version change
+	checkVersion(t, m, 1)
+}
+
+func TestDirtyAndUnknownVersions(t *testing.T) {
+	db := testDB(t)
+	ctx := testContext(t)
+	m, err := New(db, testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// As the migrate CLI leaves it after a failed migration
+	_, err = db.Exec(`UPDATE schema_migrations SET dirty = true`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := m.Status(ctx)
+	if err != nil || !s.Dirty {
+		t.Errorf("Status = %+v, %v; want dirty", s, err)
+	}
+	if _, err := m.Up(ctx, 0); !errors.Is(err, ErrDirty) {
+		t.Errorf("Up on a dirty database = %v, want ErrDirty", err)
+	}
+	if _, err := m.Down(ctx, 0); !errors.Is(err, ErrDirty) {
+		t.Errorf("Down on a dirty database = %v, want ErrDirty", err)
+	}
+
+	// As a newer build leaves it
+	_, err = db.Exec(`UPDATE schema_migrations SET version = 9, dirty = false`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = m.Status(ctx)
+	if err != nil || !s.Unknown || len(s.Pending) != 0 {
+		t.Errorf("Status = %+v, %v; want an unknown version with nothing pending", s, err)
+	}
+	rolledBack, err := m.Down(ctx, 0)
+	if err == nil || len(rolledBack) != 0 {
+		t.Errorf("Down from an unknown version = %d rolled back, %v; want an error", len(rolledBack), err)
+	}
+}
+
+// TestEmbeddedRoundTrip applies each of the app's migrations, rolls it back
+// and applies it again, so a .down.sql that doesn't undo its .up.sql fails
+// here rather than in a deploy that needs rolling back.
+func TestEmbeddedRoundTrip(t *testing.T) {
+	db := testDB(t)
+	ctx := testContext(t)
+	m, err := New(db, migrations.Files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, migration := range m.Migrations {
+		for _, step := range []string{"up", "down", "up again"} {
+			var changed []Migration
+			if step == "down" {
+				changed, err = m.Down(ctx, 1)
+			} else {
+				changed, err = m.Up(ctx, 1)
+			}
+			if err != nil {
+				t.Fatalf("version %d %s: %v", migration.Version, step, err)
+			}
+			if len(changed) != 1 || changed[0].Version != migration.Version {
+				t.Fatalf("version %d %s changed %+v", migration.Version, step, changed)
+			}
+		}
+	}
+
+	// And all the way down and up again
+	if _, err := m.Down(ctx, 0); err != nil {
+		t.Fatalf("Down(0): %v", err)
+	}
+	var tables int
+	err = db.QueryRow(`SELECT count(*) FROM pg_tables WHERE schemaname = $1 AND tablename <> 'schema_migrations'`, testSchema).Scan(&tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Errorf("%d tables left after rolling everything back, want none", tables)
+	}
+	if _, err := m.Up(ctx, 0); err != nil {
+		t.Fatalf("Up(0) after rolling back: %v", err)
+	}
+}
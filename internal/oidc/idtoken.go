@@ -0,0 +1,224 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew allows for the provider's clock being a little ahead or behind
+const clockSkew = time.Minute
+
+// keyRefreshInterval limits how often the keys are fetched again when a token
+// is signed with a key we don't know, e.g. after the provider rotated its keys
+const keyRefreshInterval = time.Minute
+
+// Claims are the ID token claims the app uses.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"` // the user's ID at the provider; never changes
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified flexBool `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// audience is the aud claim, which may be a single string or a list.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if json.Unmarshal(b, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	err := json.Unmarshal(b, &list)
+	*a = list
+	return err
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// flexBool accepts true and "true": some providers send email_verified as a string.
+type flexBool bool
+
+func (f *flexBool) UnmarshalJSON(b []byte) error {
+	switch string(b) {
+	case "true", `"true"`:
+		*f = true
+	default:
+		*f = false
+	}
+	return nil
+}
+
+// verify checks the ID token's signature and claims.
+func (p *Provider) verify(ctx context.Context, rawToken, nonce string) (*Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: ID token header: %w", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("oidc: ID token signed with unsupported algorithm %q", header.Alg)
+	}
+
+	key, err := p.keys.get(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("oidc: ID token signature: %w", err)
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+	if err != nil {
+		return nil, errors.New("oidc: ID token signature is invalid")
+	}
+
+	var claims Claims
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: ID token claims: %w", err)
+	}
+
+	now := time.Now()
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != p.Issuer:
+		return nil, fmt.Errorf("oidc: ID token issued by %q, not %q", claims.Issuer, p.Issuer)
+	case !claims.Audience.contains(p.ClientID):
+		return nil, errors.New("oidc: ID token wasn't issued to this client")
+	case len(claims.Audience) > 1 && claims.AuthorizedBy != p.ClientID:
+		return nil, errors.New("oidc: ID token wasn't issued to this client")
+	case now.After(time.Unix(claims.Expiry, 0).Add(clockSkew)):
+		return nil, errors.New("oidc: ID token has expired")
+	case now.Before(time.Unix(claims.IssuedAt, 0).Add(-clockSkew)):
+		return nil, errors.New("oidc: ID token was issued in the future")
+	case claims.Nonce != nonce:
+		return nil, errors.New("oidc: ID token nonce doesn't match")
+	case claims.Subject == "":
+		return nil, errors.New("oidc: ID token has no subject")
+	}
+	return &claims, nil
+}
+
+func decodeSegment(segment string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// keySet caches the provider's signing keys, published as a JSON Web Key Set.
+type keySet struct {
+	url      string
+	provider *Provider
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	lastFetched time.Time
+}
+
+// get returns the key with the given ID, fetching the keys again if it's unknown.
+func (ks *keySet) get(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+	if time.Since(ks.lastFetched) < keyRefreshInterval {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+
+	err := ks.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+}
+
+// lookup finds a key by ID. A token without a key ID can only use the
+// provider's one and only key.
+func (ks *keySet) lookup(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+func (ks *keySet) fetch(ctx context.Context) error {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	ks.lastFetched = time.Now()
+	err := ks.provider.getJSON(ctx, ks.url, &jwks)
+	if err != nil {
+		return fmt.Errorf("oidc: fetching signing keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	ks.keys = keys
+	return nil
+}
@@ -0,0 +1,175 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testClientID = "habit-tracker"
+	testKeyID    = "key-1"
+	testNonce    = "nonce-123"
+)
+
+// testProvider returns a provider whose keys are served by a test server, and
+// the private key that signs its tokens.
+func testProvider(t *testing.T) (*Provider, *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": testKeyID,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	t.Cleanup(ts.Close)
+
+	p := New(testIssuer, testClientID, "", "https://app.example.com/callback")
+	p.keys = &keySet{url: ts.URL, provider: p}
+	return p, key
+}
+
+// validClaims are claims verify accepts from testProvider.
+func validClaims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":   testIssuer,
+		"sub":   "user-1",
+		"aud":   testClientID,
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"nonce": testNonce,
+		"email": "user@example.com",
+	}
+}
+
+// sign makes an RS256 token of header and claims, signed with key.
+func sign(t *testing.T, key *rsa.PrivateKey, header, claims map[string]any) string {
+	t.Helper()
+
+	segment := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	signed := segment(header) + "." + segment(claims)
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerify(t *testing.T) {
+	p, key := testProvider(t)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		header  map[string]any
+		claims  func(c map[string]any)
+		key     *rsa.PrivateKey
+		nonce   string
+		wantErr string // empty if the token is valid
+	}{
+		{name: "valid"},
+		{name: "issuer with trailing slash", claims: func(c map[string]any) { c["iss"] = testIssuer + "/" }},
+		{name: "audience list with azp", claims: func(c map[string]any) {
+			c["aud"] = []string{testClientID, "other"}
+			c["azp"] = testClientID
+		}},
+		{name: "within clock skew", claims: func(c map[string]any) { c["exp"] = time.Now().Add(-30 * time.Second).Unix() }},
+
+		{name: "wrong alg", header: map[string]any{"alg": "HS256", "kid": testKeyID}, wantErr: "unsupported algorithm"},
+		{name: "no alg", header: map[string]any{"alg": "none", "kid": testKeyID}, wantErr: "unsupported algorithm"},
+		{name: "unknown kid", header: map[string]any{"alg": "RS256", "kid": "key-2"}, wantErr: "unknown signing key"},
+		{name: "bad signature", key: otherKey, wantErr: "signature is invalid"},
+		{name: "wrong issuer", claims: func(c map[string]any) { c["iss"] = "https://evil.example.com" }, wantErr: "issued by"},
+		{name: "wrong audience", claims: func(c map[string]any) { c["aud"] = "other" }, wantErr: "wasn't issued to this client"},
+		{name: "audience list without azp", claims: func(c map[string]any) { c["aud"] = []string{testClientID, "other"} }, wantErr: "wasn't issued to this client"},
+		{name: "wrong azp", claims: func(c map[string]any) {
+			c["aud"] = []string{testClientID, "other"}
+			c["azp"] = "other"
+		}, wantErr: "wasn't issued to this client"},
+		{name: "expired", claims: func(c map[string]any) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }, wantErr: "expired"},
+		{name: "issued in the future", claims: func(c map[string]any) { c["iat"] = time.Now().Add(2 * time.Minute).Unix() }, wantErr: "in the future"},
+		{name: "nonce mismatch", nonce: "other-nonce", wantErr: "nonce doesn't match"},
+		{name: "no subject", claims: func(c map[string]any) { delete(c, "sub") }, wantErr: "no subject"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == nil {
+				header = map[string]any{"alg": "RS256", "kid": testKeyID}
+			}
+			claims := validClaims()
+			if tt.claims != nil {
+				tt.claims(claims)
+			}
+			signingKey := tt.key
+			if signingKey == nil {
+				signingKey = key
+			}
+			nonce := tt.nonce
+			if nonce == "" {
+				nonce = testNonce
+			}
+
+			got, err := p.verify(context.Background(), sign(t, signingKey, header, claims), nonce)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("verify: %v", err)
+			case tt.wantErr == "":
+				if got.Subject != "user-1" || got.Email != "user@example.com" {
+					t.Errorf("claims = %+v", got)
+				}
+			case err == nil:
+				t.Fatalf("verify accepted the token, want an error containing %q", tt.wantErr)
+			case !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("verify error = %q, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	p, _ := testProvider(t)
+
+	for _, token := range []string{"", "a.b", "a.b.c.d", "!!!.e30.sig"} {
+		_, err := p.verify(context.Background(), token, testNonce)
+		if err == nil {
+			t.Errorf("verify(%q) accepted the token", token)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package sessions
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Device is one of a user's sessions, as shown on their list of devices.
+type Device struct {
+	ID         int64
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	Current    bool // the session making the request
+}
+
+// Devices lists the user's active sessions, most recently used first.
+func (s *Session) Devices(r *http.Request, userID int64) ([]Device, error) {
+	query := `
+        SELECT id, user_agent, ip, created_at, last_seen_at
+        FROM sessions
+        WHERE user_id = $1 AND expiry > $2 AND last_seen_at > $3
+        ORDER BY last_seen_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.QueryTimeout)
+	defer cancel()
+
+	now := time.Now()
+	rows, err := s.DB.QueryContext(ctx, query, userID, now, now.Add(-s.IdleTimeout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	currentID := s.state(r).id
+
+	var devices []Device
+	for rows.Next() {
+		var d Device
+		err := rows.Scan(&d.ID, &d.UserAgent, &d.IP, &d.CreatedAt, &d.LastSeenAt)
+		if err != nil {
+			return nil, err
+		}
+		d.Current = d.ID == currentID
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+// Revoke ends one of the user's sessions.
+func (s *Session) Revoke(userID, id int64) error {
+	query := `
+        DELETE FROM sessions
+        WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.QueryTimeout)
+	defer cancel()
+
+	result, err := s.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeOthers ends all of the user's sessions except the one making the request.
+func (s *Session) RevokeOthers(r *http.Request, userID int64) error {
+	query := `
+        DELETE FROM sessions
+        WHERE user_id = $1 AND id <> $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.QueryTimeout)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, query, userID, s.state(r).id)
+	return err
+}
+
+// DeleteExpired removes sessions that have expired or been idle for too long.
+func (s *Session) DeleteExpired() error {
+	query := `
+        DELETE FROM sessions
+        WHERE expiry <= $1 OR last_seen_at <= $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second) // may be a large delete
+	defer cancel()
+
+	now := time.Now()
+	_, err := s.DB.ExecContext(ctx, query, now, now.Add(-s.IdleTimeout))
+	return err
+}
@@ -0,0 +1,434 @@
+// Package sessions keeps session data in PostgreSQL. The cookie only holds a
+// random token, so a session can be revoked on the server, e.g. from the
+// user's list of devices. The API follows the cookie sessions it replaced:
+// wrap the router with Enable, then Put, Get, PopString and Remove values in
+// handlers.
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const cookieName = "session"
+
+// touchInterval limits how often last_seen_at is updated for sessions whose
+// data hasn't changed
+const touchInterval = time.Minute
+
+var ErrNotFound = errors.New("session not found")
+
+// Session holds the configuration settings for sessions and the database
+// they are stored in.
+type Session struct {
+	DB *sql.DB
+
+	// Lifetime is the absolute expiry of a session, set when it is created.
+	// The default is 7 days.
+	Lifetime time.Duration
+
+	// IdleTimeout ends sessions that haven't been used for this long. The
+	// default is 12 hours.
+	IdleTimeout time.Duration
+
+	// QueryTimeout limits each query to the sessions table. The default is
+	// 3 seconds.
+	QueryTimeout time.Duration
+
+	// Secure sets the 'Secure' attribute on the session cookie.
+	Secure bool
+
+	// UserIDKey is the key whose int64 value is copied into the user_id
+	// column, so a user's sessions can be listed and revoked.
+	UserIDKey string
+
+	// ClientIP returns the IP address recorded for the device a request came
+	// from. By default it's the address the connection came from.
+	ClientIP func(*http.Request) string
+
+	// ErrorHandler is called when the session can't be loaded or saved. By
+	// default the error is logged and the client gets a 500 response.
+	ErrorHandler func(http.ResponseWriter, *http.Request, error)
+}
+
+// New creates a Session stored in db with the default settings.
+func New(db *sql.DB) *Session {
+	return &Session{
+		DB:           db,
+		Lifetime:     7 * 24 * time.Hour,
+		IdleTimeout:  12 * time.Hour,
+		QueryTimeout: 3 * time.Second,
+		ClientIP:     remoteIP,
+		ErrorHandler: defaultErrorHandler,
+	}
+}
+
+// state is the session of a single request.
+type state struct {
+	mu        sync.Mutex
+	id        int64 // row ID, 0 until the session is stored
+	data      map[string]any
+	expiry    time.Time
+	lastSeen  time.Time
+	hadCookie bool
+	modified  bool
+	renew     bool // a new token must be issued
+	destroyed bool // the stored row must be deleted
+}
+
+type contextKey string
+
+const stateContextKey = contextKey("session")
+
+func (s *Session) state(r *http.Request) *state {
+	st, ok := r.Context().Value(stateContextKey).(*state)
+	if !ok {
+		panic("sessions: the request wasn't passed through Session.Enable")
+	}
+	return st
+}
+
+// Enable is middleware which loads the session before calling next and saves
+// it just before the response headers are written.
+func (s *Session) Enable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st, err := s.load(r)
+		if err != nil {
+			s.ErrorHandler(w, r, err)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), stateContextKey, st))
+
+		sw := &sessionWriter{ResponseWriter: w, session: s, request: r, state: st}
+		next.ServeHTTP(sw, r)
+		sw.commit()
+	})
+}
+
+func (s *Session) load(r *http.Request) (*state, error) {
+	st := &state{data: make(map[string]any)}
+
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return st, nil
+	}
+	st.hadCookie = true
+
+	query := `
+        SELECT id, data, expiry, last_seen_at
+        FROM sessions
+        WHERE token_hash = $1 AND expiry > $2 AND last_seen_at > $3`
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.QueryTimeout)
+	defer cancel()
+
+	now := time.Now()
+	var encoded []byte
+	err = s.DB.QueryRowContext(ctx, query, hashToken(cookie.Value), now, now.Add(-s.IdleTimeout)).Scan(
+		&st.id,
+		&encoded,
+		&st.expiry,
+		&st.lastSeen,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return st, nil // expired or revoked: start afresh
+		}
+		return nil, err
+	}
+
+	err = gob.NewDecoder(bytes.NewReader(encoded)).Decode(&st.data)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// save stores the session and sets or clears the cookie as needed.
+func (s *Session) save(w http.ResponseWriter, r *http.Request, st *state) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.QueryTimeout)
+	defer cancel()
+
+	if st.destroyed && st.id != 0 {
+		_, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1`, st.id)
+		if err != nil {
+			return err
+		}
+		st.id = 0
+	}
+
+	// Nothing worth storing: just clear a cookie that no longer leads anywhere
+	if st.id == 0 && len(st.data) == 0 {
+		if st.hadCookie && st.destroyed {
+			s.setCookie(w, "", time.Unix(1, 0))
+		}
+		return nil
+	}
+
+	now := time.Now()
+	if st.id != 0 && !st.modified && !st.renew {
+		if now.Sub(st.lastSeen) < touchInterval {
+			return nil
+		}
+		_, err := s.DB.ExecContext(ctx, `UPDATE sessions SET last_seen_at = $1, ip = $2 WHERE id = $3`,
+			now, s.ClientIP(r), st.id)
+		return err
+	}
+
+	var encoded bytes.Buffer
+	err := gob.NewEncoder(&encoded).Encode(st.data)
+	if err != nil {
+		return err
+	}
+
+	var userID sql.NullInt64
+	if id, ok := st.data[s.UserIDKey].(int64); ok && s.UserIDKey != "" {
+		userID = sql.NullInt64{Int64: id, Valid: true}
+	}
+
+	switch {
+	case st.id == 0:
+		token, err := generateToken()
+		if err != nil {
+			return err
+		}
+		st.expiry = now.Add(s.Lifetime)
+
+		query := `
+            INSERT INTO sessions (token_hash, user_id, data, user_agent, ip, last_seen_at, expiry)
+            VALUES ($1, $2, $3, $4, $5, $6, $7)
+            RETURNING id`
+		err = s.DB.QueryRowContext(ctx, query, hashToken(token), userID, encoded.Bytes(),
+			r.UserAgent(), s.ClientIP(r), now, st.expiry).Scan(&st.id)
+		if err != nil {
+			return err
+		}
+		s.setCookie(w, token, st.expiry)
+
+	case st.renew:
+		token, err := generateToken()
+		if err != nil {
+			return err
+		}
+
+		query := `
+            UPDATE sessions
+            SET token_hash = $1, user_id = $2, data = $3, user_agent = $4, ip = $5, last_seen_at = $6
+            WHERE id = $7`
+		_, err = s.DB.ExecContext(ctx, query, hashToken(token), userID, encoded.Bytes(),
+			r.UserAgent(), s.ClientIP(r), now, st.id)
+		if err != nil {
+			return err
+		}
+		s.setCookie(w, token, st.expiry)
+
+	default:
+		query := `
+            UPDATE sessions
+            SET user_id = $1, data = $2, ip = $3, last_seen_at = $4
+            WHERE id = $5`
+		_, err = s.DB.ExecContext(ctx, query, userID, encoded.Bytes(), s.ClientIP(r), now, st.id)
+		if err != nil {
+			return err
+		}
+	}
+
+	st.lastSeen = now
+	st.modified, st.renew, st.destroyed = false, false, false
+	return nil
+}
+
+func (s *Session) setCookie(w http.ResponseWriter, token string, expiry time.Time) {
+	cookie := &http.Cookie{
+		Name:     cookieName,
+		Value:    token,
+		Path:     "/",
+		Secure:   s.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  expiry,
+	}
+	if token == "" {
+		cookie.MaxAge = -1
+	}
+	w.Header().Add("Vary", "Cookie")
+	http.SetCookie(w, cookie)
+}
+
+// Put adds a key and value to the session, replacing any existing value.
+func (s *Session) Put(r *http.Request, key string, val any) {
+	st := s.state(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.data[key] = val
+	st.modified = true
+}
+
+// Get returns the value for key, or nil if there isn't one.
+func (s *Session) Get(r *http.Request, key string) any {
+	st := s.state(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.data[key]
+}
+
+// GetString returns the string value for key, or "" if there isn't one.
+func (s *Session) GetString(r *http.Request, key string) string {
+	val, _ := s.Get(r, key).(string)
+	return val
+}
+
+// PopString returns the string value for key and removes it from the session.
+func (s *Session) PopString(r *http.Request, key string) string {
+	st := s.state(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	val, ok := st.data[key].(string)
+	if !ok {
+		return ""
+	}
+	delete(st.data, key)
+	st.modified = true
+	return val
+}
+
+// Exists reports whether the session has a value for key.
+func (s *Session) Exists(r *http.Request, key string) bool {
+	st := s.state(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	_, ok := st.data[key]
+	return ok
+}
+
+// Remove deletes key from the session.
+func (s *Session) Remove(r *http.Request, key string) {
+	st := s.state(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, ok := st.data[key]; !ok {
+		return
+	}
+	delete(st.data, key)
+	st.modified = true
+}
+
+// RenewToken gives the session a new token, keeping its data. Call it when
+// the user logs in so a token planted before login (session fixation)
+// doesn't end up authenticated.
+func (s *Session) RenewToken(r *http.Request) {
+	st := s.state(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.renew = true
+}
+
+// Destroy deletes the session. Anything Put afterwards starts a new one.
+func (s *Session) Destroy(r *http.Request) {
+	st := s.state(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.data = make(map[string]any)
+	st.destroyed = true
+	st.modified = true
+}
+
+// sessionWriter saves the session just before the response headers are
+// written, so the cookie can still be set.
+type sessionWriter struct {
+	http.ResponseWriter
+	session   *Session
+	request   *http.Request
+	state     *state
+	committed bool
+	failed    bool
+}
+
+func (sw *sessionWriter) commit() {
+	if sw.committed {
+		return
+	}
+	sw.committed = true
+
+	err := sw.session.save(sw.ResponseWriter, sw.request, sw.state)
+	if err != nil {
+		sw.failed = true
+		sw.session.ErrorHandler(sw.ResponseWriter, sw.request, err)
+	}
+}
+
+func (sw *sessionWriter) WriteHeader(code int) {
+	sw.commit()
+	if sw.failed {
+		return
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *sessionWriter) Write(b []byte) (int, error) {
+	sw.commit()
+	if sw.failed {
+		return len(b), nil
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *sessionWriter) Flush() {
+	sw.commit()
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok && !sw.failed {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (sw *sessionWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func defaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	log.Output(2, err.Error())
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
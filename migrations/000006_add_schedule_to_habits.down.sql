@@ -0,0 +1,9 @@
+ALTER TABLE habits
+DROP CONSTRAINT IF EXISTS habits_weekdays_check;
+
+ALTER TABLE habits
+DROP CONSTRAINT IF EXISTS habits_times_per_week_check;
+
+ALTER TABLE habits
+DROP COLUMN IF EXISTS weekdays,
+DROP COLUMN IF EXISTS times_per_week;
@@ -0,0 +1,12 @@
+-- Structured schedule for 'custom' habits.
+-- times_per_week: e.g. 3 for "gym 3 times/week" (0 when unused)
+-- weekdays: bitmask of scheduled days, Sunday = 1, Monday = 2, ... Saturday = 64 (0 when unused)
+ALTER TABLE habits
+ADD COLUMN times_per_week SMALLINT NOT NULL DEFAULT 0,
+ADD COLUMN weekdays SMALLINT NOT NULL DEFAULT 0;
+
+ALTER TABLE habits
+ADD CONSTRAINT habits_times_per_week_check CHECK (times_per_week BETWEEN 0 AND 7);
+
+ALTER TABLE habits
+ADD CONSTRAINT habits_weekdays_check CHECK (weekdays BETWEEN 0 AND 127);
@@ -0,0 +1,9 @@
+ALTER TABLE habit_entries
+DROP COLUMN IF EXISTS value;
+
+ALTER TABLE habits
+DROP CONSTRAINT IF EXISTS habits_target_value_check;
+
+ALTER TABLE habits
+DROP COLUMN IF EXISTS unit,
+DROP COLUMN IF EXISTS target_value;
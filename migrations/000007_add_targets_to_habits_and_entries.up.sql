@@ -0,0 +1,12 @@
+-- Quantitative habits, e.g. "drink 2 L of water" or "read 30 pages".
+-- A target_value of 0 means the habit is a simple done/skipped habit.
+ALTER TABLE habits
+ADD COLUMN target_value NUMERIC(10, 2) NOT NULL DEFAULT 0,
+ADD COLUMN unit VARCHAR(20) NOT NULL DEFAULT '';
+
+ALTER TABLE habits
+ADD CONSTRAINT habits_target_value_check CHECK (target_value >= 0);
+
+-- Amount logged for the day, accumulated across increments.
+ALTER TABLE habit_entries
+ADD COLUMN value NUMERIC(10, 2) NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+ALTER TABLE users
+DROP CONSTRAINT IF EXISTS users_day_start_hour_check;
+
+ALTER TABLE users
+DROP COLUMN IF EXISTS day_start_hour,
+DROP COLUMN IF EXISTS timezone;
@@ -0,0 +1,8 @@
+-- Each user's "today" is resolved in their own time zone.
+-- day_start_hour lets night owls keep logging onto the previous day until e.g. 3 AM.
+ALTER TABLE users
+ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC',
+ADD COLUMN day_start_hour SMALLINT NOT NULL DEFAULT 0;
+
+ALTER TABLE users
+ADD CONSTRAINT users_day_start_hour_check CHECK (day_start_hour BETWEEN 0 AND 6);
@@ -0,0 +1,16 @@
+-- Personal access tokens for scripts and other non-browser clients.
+-- Only the SHA-256 hash of a token is stored; the plaintext is shown once when it is created.
+-- scope: 'read' allows GET requests only, 'write' allows everything.
+-- expiry: NULL for tokens that never expire.
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id SERIAL PRIMARY KEY,
+    hash BYTEA NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    scope VARCHAR(10) NOT NULL CHECK (scope IN ('read', 'write')),
+    expiry TIMESTAMP(0) WITH TIME ZONE,
+    last_used_at TIMESTAMP(0) WITH TIME ZONE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens (user_id);
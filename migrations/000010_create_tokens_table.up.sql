@@ -0,0 +1,11 @@
+-- Single-use tokens sent by email, e.g. to activate a new account.
+-- Only the SHA-256 hash of a token is stored; the plaintext is only ever in the email.
+-- scope: what the token can be used for, e.g. 'activation'.
+CREATE TABLE IF NOT EXISTS tokens (
+    hash BYTEA PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    scope TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_tokens_user_id_scope ON tokens (user_id, scope);
@@ -0,0 +1,4 @@
+-- Stored in the session at login. Bumping it (e.g. after a password reset)
+-- logs out every existing session of the user.
+ALTER TABLE users
+ADD COLUMN session_version INTEGER NOT NULL DEFAULT 1;
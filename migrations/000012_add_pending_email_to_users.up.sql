@@ -0,0 +1,4 @@
+-- A new email address waiting to be confirmed from a link sent to it.
+-- The account keeps using email until then.
+ALTER TABLE users
+ADD COLUMN pending_email citext;
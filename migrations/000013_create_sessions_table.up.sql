@@ -0,0 +1,17 @@
+-- Server-side sessions. The cookie holds a random token; only its SHA-256 hash is stored.
+-- user_id is set while someone is logged in, so they can list and revoke their sessions.
+-- data: the gob-encoded session values.
+CREATE TABLE IF NOT EXISTS sessions (
+    id BIGSERIAL PRIMARY KEY,
+    token_hash BYTEA NOT NULL UNIQUE,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    data BYTEA NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expiry ON sessions (expiry);
@@ -0,0 +1,16 @@
+-- Two-factor authentication. totp_secret is set once the user has enrolled an
+-- authenticator app; totp_last_step is the time step of the last accepted
+-- code, so a code can't be used twice.
+ALTER TABLE users
+ADD COLUMN totp_secret TEXT,
+ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- One-time recovery codes for logging in without the authenticator app.
+-- Only the SHA-256 hash of a code is stored; the plaintext is shown once.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    hash BYTEA PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
@@ -0,0 +1,12 @@
+-- Accounts at an OpenID Connect provider linked to a user, so single sign-on
+-- keeps finding the same user even if their email address changes.
+-- subject: the provider's ID for the user (the ID token's sub claim).
+CREATE TABLE IF NOT EXISTS user_identities (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
@@ -0,0 +1,13 @@
+-- has_password is false for accounts created by single sign-on, whose
+-- password is random until the user sets one from a reset link. Accounts
+-- linked to an identity within a minute of being created are taken to be
+-- those; at worst a user is asked to set a password they already have.
+ALTER TABLE users
+ADD COLUMN has_password BOOLEAN NOT NULL DEFAULT true;
+
+UPDATE users SET has_password = false
+WHERE id IN (
+    SELECT u.id FROM users u
+    JOIN user_identities i ON i.user_id = u.id
+    WHERE i.created_at - u.created_at < INTERVAL '1 minute'
+);
@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migrations, so the app can bring the
+// database schema up to date itself. Files are named
+// NNNNNN_description.up.sql and NNNNNN_description.down.sql, as the migrate
+// CLI expects.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var Files embed.FS